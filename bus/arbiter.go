@@ -0,0 +1,84 @@
+package bus
+
+// This constants are the arbitration policies, choosing which masters
+// transfer their messages first when the lanes cannot carry them all
+const (
+	// FIFO transfers the messages in the order they were sent
+	FIFO = iota
+	// ROUNDROBIN rotates the highest priority between the masters on
+	// every transfer
+	ROUNDROBIN
+	// PRIORITY always favors the masters attached first
+	PRIORITY
+	// TDMA gives every transfer to a single master, in turns
+	TDMA
+)
+
+var policies = map[string]int{
+	"fifo":        FIFO,
+	"round-robin": ROUNDROBIN,
+	"priority":    PRIORITY,
+	"tdma":        TDMA,
+}
+
+// ParseArbitration returns the arbitration policy with the given name
+func ParseArbitration(name string) (int, bool) {
+	policy, ok := policies[name]
+	return policy, ok
+}
+
+// Arbiter grants the bus on every transfer. It receives the masters in the
+// order they were attached and the number of the transfer, returning the
+// masters allowed to transfer from the highest priority to the lowest, or
+// nil to transfer the messages in the order they were sent
+type Arbiter interface {
+	Grant([]string, int) []string
+}
+
+// NewArbiter returns the arbiter of a policy
+func NewArbiter(policy int) Arbiter {
+	switch policy {
+	case ROUNDROBIN:
+		return roundRobin{}
+	case PRIORITY:
+		return priority{}
+	case TDMA:
+		return tdma{}
+	default:
+		return fifo{}
+	}
+}
+
+type fifo struct{}
+
+func (fifo) Grant(masters []string, transfer int) []string {
+	return nil
+}
+
+type roundRobin struct{}
+
+func (roundRobin) Grant(masters []string, transfer int) []string {
+	if len(masters) == 0 {
+		return masters
+	}
+
+	first := transfer % len(masters)
+
+	return append(append([]string{}, masters[first:]...), masters[:first]...)
+}
+
+type priority struct{}
+
+func (priority) Grant(masters []string, transfer int) []string {
+	return masters
+}
+
+type tdma struct{}
+
+func (tdma) Grant(masters []string, transfer int) []string {
+	if len(masters) == 0 {
+		return masters
+	}
+
+	return []string{masters[transfer%len(masters)]}
+}
@@ -0,0 +1,56 @@
+package bus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGrant(t *testing.T) {
+	masters := []string{"cpu0", "cpu1", "io"}
+
+	tests := []struct {
+		policy  int
+		granted [][]string
+	}{
+		{FIFO, [][]string{nil, nil, nil, nil}},
+		{ROUNDROBIN, [][]string{
+			{"cpu0", "cpu1", "io"},
+			{"cpu1", "io", "cpu0"},
+			{"io", "cpu0", "cpu1"},
+			{"cpu0", "cpu1", "io"},
+		}},
+		{PRIORITY, [][]string{
+			{"cpu0", "cpu1", "io"},
+			{"cpu0", "cpu1", "io"},
+			{"cpu0", "cpu1", "io"},
+			{"cpu0", "cpu1", "io"},
+		}},
+		{TDMA, [][]string{{"cpu0"}, {"cpu1"}, {"io"}, {"cpu0"}}},
+	}
+
+	for _, test := range tests {
+		arbiter := NewArbiter(test.policy)
+
+		for transfer, want := range test.granted {
+			if granted := arbiter.Grant(masters, transfer); !reflect.DeepEqual(granted, want) {
+				t.Errorf("policy %d, transfer %d: granted %v, want %v", test.policy, transfer, granted, want)
+			}
+		}
+
+		if granted := arbiter.Grant([]string{}, 1); len(granted) != 0 {
+			t.Errorf("policy %d: granted %v without masters", test.policy, granted)
+		}
+	}
+}
+
+func TestParseArbitration(t *testing.T) {
+	for name, policy := range map[string]int{"fifo": FIFO, "round-robin": ROUNDROBIN, "priority": PRIORITY, "tdma": TDMA} {
+		if parsed, ok := ParseArbitration(name); !ok || parsed != policy {
+			t.Errorf("ParseArbitration(%q) = %d, %v, want %d", name, parsed, ok, policy)
+		}
+	}
+
+	if _, ok := ParseArbitration("lottery"); ok {
+		t.Errorf("ParseArbitration accepted an unknown policy")
+	}
+}
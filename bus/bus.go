@@ -2,16 +2,55 @@ package bus
 
 import (
 	"container/list"
-	"time"
+	"sync"
 
+	"github.com/bruunoromero/cpu-emulator/logger"
 	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/sim"
 )
 
 type bus struct {
-	length    int
-	frequency int
-	buffer    *list.List
-	channels  map[string]chan msg
+	length   int
+	period   int
+	buffer   *list.List
+	mutex    sync.Mutex
+	devices  []Device
+	kernel   sim.Instance
+	channels map[string][]msg
+	owner    string
+	// transactions numbers the messages sent, the chunks of a transaction
+	// left in the buffer are counted once some of them were transferred
+	transactions int
+	started      map[int]int
+	snoopers     []Snooper
+	arbiter      Arbiter
+	masters      []string
+	tracers      []Tracer
+	log          logger.Logger
+	stats        Stats
+}
+
+// Stats holds the counters of the bus
+type Stats struct {
+	Reads   int
+	Writes  int
+	Replies int
+	// Coherence is the number of coherence transactions broadcast to the
+	// caches
+	Coherence int
+	// Invalidations is the number of lines invalidated by them
+	Invalidations int
+	// Flushes is the number of dirty lines written back by them
+	Flushes int
+	// Locks is the number of times the bus was reserved by an atomic
+	// instruction
+	Locks int
+	// Transfers is the number of transfers of the bus
+	Transfers int
+	// Masters and Lanes hold the usage of the bus by every master and
+	// lane
+	Masters map[string]Usage
+	Lanes   map[string]Usage
 }
 
 type Action struct {
@@ -22,14 +61,20 @@ type Action struct {
 
 // Msg represents a message to be received from the bus
 type msg struct {
-	channel string
-	action  Action
+	channel     string
+	destination string
+	lane        string
+	since       int
+	transaction int
+	action      Action
 }
 
-// READ and WRITE are the possible signals of an bus operation
+// READ and WRITE are the possible signals of an bus operation, REPLY is
+// the signal of the answers of the devices
 const (
 	READ = iota
 	WRITE
+	REPLY
 )
 
 // DATA constant
@@ -46,25 +91,158 @@ var lanes = []string{ADDRESS, DATA, INSTUCTION}
 // Instance is the interface of the bus type
 type Instance interface {
 	Run()
+	Idle() bool
+	Period() int
+	Kernel() sim.Instance
+	Stats() Stats
+	Masters() []string
+	Trace(Tracer)
+	Lock(string) bool
+	Unlock(string)
+	Observe(Snooper)
+	Broadcast(string, int, int, int) Snoop
+	Attach(Device)
+	Device(int) Device
+	Read(int) []parser.Msg
+	Write(int, []parser.Msg)
 	MakeChannel(string)
 	ReceiveFrom(string) *Action
 	SendTo(string, string, int, []parser.Msg)
+	Route(string, int, int, []parser.Msg)
+	Snapshot() Snapshot
+	LoadSnapshot(Snapshot)
 }
 
-// New returns a new instance of bus
-func New(frequency int, length int) Instance {
+// New returns a new instance of bus, clocked by the given kernel and
+// transferring once every period cycles. The arbiter chooses the masters
+// transferring first
+func New(kernel sim.Instance, length int, period int, arbiter Arbiter) Instance {
 	return &bus{
-		length:    length,
-		frequency: frequency,
-		buffer:    list.New(),
-		channels:  make(map[string]chan msg),
+		length:   length,
+		period:   period,
+		kernel:   kernel,
+		arbiter:  arbiter,
+		buffer:   list.New(),
+		devices:  make([]Device, 0),
+		masters:  make([]string, 0),
+		channels: make(map[string][]msg),
+		started:  make(map[int]int),
+		log:      logger.For(logger.BUS),
+		stats: Stats{
+			Masters: make(map[string]Usage),
+			Lanes:   make(map[string]Usage),
+		},
+	}
+}
+
+// Period returns the number of cycles between two transfers of the bus
+func (bus *bus) Period() int {
+	return bus.period
+}
+
+func (bus *bus) Kernel() sim.Instance {
+	return bus.kernel
+}
+
+// Stats returns a copy of the counters of the bus
+func (bus *bus) Stats() Stats {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	stats := bus.stats
+	stats.Masters = make(map[string]Usage)
+	stats.Lanes = make(map[string]Usage)
+
+	for master, usage := range bus.stats.Masters {
+		stats.Masters[master] = usage
+	}
+
+	for lane, usage := range bus.stats.Lanes {
+		stats.Lanes[lane] = usage
+	}
+
+	return stats
+}
+
+// Masters returns the masters of the bus in the order they were attached,
+// the origins not attached are added when they first send a message
+func (bus *bus) Masters() []string {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	return append([]string{}, bus.masters...)
+}
+
+func (bus *bus) master(origin string) {
+	for _, master := range bus.masters {
+		if master == origin {
+			return
+		}
+	}
+
+	bus.masters = append(bus.masters, origin)
+}
+
+// Lock reserves the bus for an origin, telling if it succeeded. Until it is
+// unlocked only the transactions of the origin, the replies of the devices
+// and the rest of the transactions partly transferred are transferred
+func (bus *bus) Lock(origin string) bool {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.owner == origin {
+		return true
+	}
+
+	if bus.owner != "" {
+		return false
+	}
+
+	bus.owner = origin
+	bus.stats.Locks++
+	bus.log.Debug("locked", "cycle", bus.kernel.Cycle(), "owner", origin)
+
+	return true
+}
+
+// Unlock releases the bus reserved by an origin
+func (bus *bus) Unlock(origin string) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.owner == origin {
+		bus.owner = ""
+		bus.log.Debug("unlocked", "cycle", bus.kernel.Cycle(), "owner", origin)
 	}
 }
 
 func (bus *bus) MakeChannel(channel string) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
 	for _, lane := range lanes {
-		bus.channels[channel+lane] = make(chan msg)
+		bus.channels[channel+lane] = make([]msg, 0)
+	}
+
+	bus.master(channel)
+}
+
+// Idle tells if there are no messages buffered or waiting to be received
+func (bus *bus) Idle() bool {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.buffer.Len() > 0 {
+		return false
+	}
+
+	for _, channel := range bus.channels {
+		if len(channel) > 0 {
+			return false
+		}
 	}
+
+	return true
 }
 
 func getLane(msg parser.Msg) string {
@@ -83,6 +261,14 @@ func (bus *bus) SendTo(channel string, origin string, signal int, payload []pars
 	lanes := categorizeMsgs(payload)
 	expandedLanes := bus.expandCategories(lanes)
 
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.count(signal)
+	bus.master(origin)
+	bus.transactions++
+	bus.log.Debug("send", "cycle", bus.kernel.Cycle(), "origin", origin, "destination", channel, "signal", signalName(signal), "words", len(payload))
+
 	for _, category := range expandedLanes {
 		for lane, msgs := range category {
 			for i := range msgs {
@@ -91,18 +277,36 @@ func (bus *bus) SendTo(channel string, origin string, signal int, payload []pars
 			}
 
 			act := Action{Payload: msgs, Origin: origin, Signal: signal}
-			bus.buffer.PushBack(msg{channel: channel + lane, action: act})
+			el := msg{channel: channel + lane, destination: channel, lane: lane, since: bus.kernel.Cycle(), transaction: bus.transactions, action: act}
+			bus.buffer.PushBack(el)
+			bus.record(ENQUEUE, el)
 		}
 	}
 }
 
+func (bus *bus) count(signal int) {
+	switch signal {
+	case READ:
+		bus.stats.Reads++
+	case WRITE:
+		bus.stats.Writes++
+	case REPLY:
+		bus.stats.Replies++
+	}
+}
+
 func (bus *bus) ReceiveFrom(channel string) *Action {
-	select {
-	case msg := <-bus.channels[channel]:
-		return &msg.action
-	default:
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if len(bus.channels[channel]) == 0 {
 		return &Action{}
 	}
+
+	msg := bus.channels[channel][0]
+	bus.channels[channel] = bus.channels[channel][1:]
+
+	return &msg.action
 }
 
 func (bus *bus) expandCategories(categories map[string][]parser.Msg) []map[string][]parser.Msg {
@@ -155,12 +359,16 @@ func categorizeMsgs(msgs []parser.Msg) map[string][]parser.Msg {
 func (bus *bus) send(front *list.Element, channelsLength map[string]int) bool {
 	if front != nil && front.Value != nil {
 		el := front.Value.(msg)
+		if bus.owner != "" && el.action.Origin != bus.owner && el.action.Signal != REPLY && bus.started[el.transaction] == 0 {
+			return false
+		}
+
 		size := len(el.action.Payload) * 8
 		if channelsLength[el.channel]+size <= bus.length {
 			channelsLength[el.channel] += size
-			go func() {
-				bus.channels[el.channel] <- el
-			}()
+			bus.channels[el.channel] = append(bus.channels[el.channel], el)
+			bus.record(DELIVER, el)
+			bus.start(front)
 			return true
 		}
 	}
@@ -168,22 +376,85 @@ func (bus *bus) send(front *list.Element, channelsLength map[string]int) bool {
 	return false
 }
 
-func (bus *bus) Run() {
-	go func() {
-		for {
-			sent := make([]*list.Element, 0)
-			channelsLength := make(map[string]int)
-			for front := bus.buffer.Front(); front != nil; front = front.Next() {
-				if bus.send(front, channelsLength) {
-					sent = append(sent, front)
-				}
-			}
+// start counts the chunks of the transaction of a message transferred
+// which are left in the buffer, the receivers wait for all of them
+func (bus *bus) start(front *list.Element) {
+	transaction := front.Value.(msg).transaction
 
-			for _, msg := range sent {
-				bus.buffer.Remove(msg)
+	if bus.started[transaction] == 0 {
+		for next := bus.buffer.Front(); next != nil; next = next.Next() {
+			if next.Value.(msg).transaction == transaction {
+				bus.started[transaction]++
 			}
+		}
+	}
 
-			time.Sleep(time.Second / time.Duration(bus.frequency))
+	bus.started[transaction]--
+	if bus.started[transaction] == 0 {
+		delete(bus.started, transaction)
+	}
+}
+
+func (bus *bus) tick() {
+	for _, device := range bus.devices {
+		if clocked, ok := device.(Clocked); ok {
+			clocked.Tick()
 		}
-	}()
+	}
+}
+
+func (bus *bus) cycle() {
+	bus.transfer()
+	bus.tick()
+}
+
+// transfer moves the buffered messages into the channels, as long as the
+// channels can carry them. The messages of the masters granted first are
+// offered first, in the order they were sent
+func (bus *bus) transfer() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.sample()
+
+	granted := bus.arbiter.Grant(bus.masters, bus.stats.Transfers)
+	bus.stats.Transfers++
+
+	sent := make([]*list.Element, 0)
+	busy := make(map[string]bool)
+	channelsLength := make(map[string]int)
+
+	offer := func(front *list.Element) {
+		if bus.send(front, channelsLength) {
+			sent = append(sent, front)
+			bus.account(front.Value.(msg), busy)
+		}
+	}
+
+	if granted == nil {
+		for front := bus.buffer.Front(); front != nil; front = front.Next() {
+			offer(front)
+		}
+	}
+
+	for _, master := range granted {
+		for front := bus.buffer.Front(); front != nil; front = front.Next() {
+			if front.Value.(msg).action.Origin == master {
+				offer(front)
+			}
+		}
+	}
+
+	for _, msg := range sent {
+		bus.buffer.Remove(msg)
+	}
+}
+
+// Run schedules the bus transfers and starts the attached devices
+func (bus *bus) Run() {
+	bus.kernel.Every(bus.period, bus.cycle)
+
+	for _, device := range bus.devices {
+		device.Run(bus)
+	}
 }
@@ -0,0 +1,101 @@
+package bus
+
+import (
+	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/utils"
+)
+
+// IOBASE is the first address of the memory-mapped I/O region
+const IOBASE = 0x100
+
+// Device is the interface every peripheral attached to the bus must satisfy.
+// A device owns the addresses in [Base, Base+Size) and the bus routes every
+// request inside that range to the channel with the device name. Read and
+// Write receive addresses relative to the device base.
+type Device interface {
+	Name() string
+	Range() (int, int)
+	Run(Instance)
+	Read(int) []parser.Msg
+	Write(int, []parser.Msg)
+}
+
+// Clocked is implemented by the devices that must be notified of every
+// bus cycle
+type Clocked interface {
+	Tick()
+}
+
+func overlaps(left Device, right Device) bool {
+	leftBase, leftSize := left.Range()
+	rightBase, rightSize := right.Range()
+
+	return leftBase < rightBase+rightSize && rightBase < leftBase+leftSize
+}
+
+func contains(device Device, address int) bool {
+	base, size := device.Range()
+
+	return address >= base && address < base+size
+}
+
+// Attach registers a device on the bus, creating its channels. The device
+// is started together with the bus when Run is called
+func (bus *bus) Attach(device Device) {
+	for _, attached := range bus.devices {
+		if attached.Name() == device.Name() {
+			utils.Abort("A device named " + device.Name() + " is already attached")
+		}
+
+		if overlaps(attached, device) {
+			utils.Abort("Address range of " + device.Name() + " overlaps " + attached.Name())
+		}
+	}
+
+	bus.MakeChannel(device.Name())
+	bus.devices = append(bus.devices, device)
+}
+
+// Device returns the device mapped at the given address, or nil when the
+// address is not mapped
+func (bus *bus) Device(address int) Device {
+	for _, device := range bus.devices {
+		if contains(device, address) {
+			return device
+		}
+	}
+
+	return nil
+}
+
+func (bus *bus) deviceOrAbort(address int) Device {
+	device := bus.Device(address)
+
+	if device == nil {
+		utils.Abort("No device mapped at the requested address")
+	}
+
+	return device
+}
+
+// Read reads directly from the device mapped at the given address
+func (bus *bus) Read(address int) []parser.Msg {
+	device := bus.deviceOrAbort(address)
+	base, _ := device.Range()
+
+	return device.Read(address - base)
+}
+
+// Write writes directly to the device mapped at the given address
+func (bus *bus) Write(address int, payload []parser.Msg) {
+	device := bus.deviceOrAbort(address)
+	base, _ := device.Range()
+
+	device.Write(address-base, payload)
+}
+
+// Route sends a payload to the device mapped at the given address
+func (bus *bus) Route(origin string, signal int, address int, payload []parser.Msg) {
+	device := bus.deviceOrAbort(address)
+	bus.SendTo(device.Name(), origin, signal, payload)
+}
@@ -0,0 +1,147 @@
+package bus
+
+import (
+	"sort"
+
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// Port serves the transactions addressed to a device. Reads are answered
+// with the value read from the device and writes are acknowledged, both
+// with a REPLY carrying the key of the request, latency cycles after the
+// request is received
+type Port struct {
+	device  Device
+	latency int
+	queue   []parser.Msg
+	replies map[int]Reply
+	decoder parser.Decoder
+}
+
+// Reply is an answer of a port waiting for the latency of its device, it
+// is sent on the cycle it was scheduled for
+type Reply struct {
+	Cycle   int
+	Order   int
+	Origin  string
+	Payload []parser.Msg
+}
+
+// PortSnapshot is the state of a port, the words of the message being
+// received and the replies not sent yet
+type PortSnapshot struct {
+	Queue   []parser.Msg
+	Replies []Reply
+}
+
+// NewPort returns a new port for the given device
+func NewPort(device Device, decoder parser.Decoder, latency int) *Port {
+	return &Port{
+		device:  device,
+		latency: latency,
+		decoder: decoder,
+		queue:   make([]parser.Msg, 0),
+		replies: make(map[int]Reply),
+	}
+}
+
+// Run serves the transactions once per cycle
+func (port *Port) Run(bus Instance) {
+	bus.Kernel().Every(1, func() {
+		for _, message := range port.Receive(bus) {
+			port.Serve(bus, message)
+		}
+	})
+}
+
+// Receive returns the complete messages received by the device
+func (port *Port) Receive(bus Instance) [][]parser.Msg {
+	name := port.device.Name()
+
+	data := bus.ReceiveFrom(name + DATA)
+	address := bus.ReceiveFrom(name + ADDRESS)
+	instructions := bus.ReceiveFrom(name + INSTUCTION)
+
+	messages := port.decoder.GetMessagesWithQueue(address.Payload, data.Payload, instructions.Payload, &port.queue)
+	complete := make([][]parser.Msg, 0)
+
+	for _, message := range messages {
+		if len(message) > 0 {
+			complete = append(complete, message)
+		}
+	}
+
+	return complete
+}
+
+// Serve answers a READ or WRITE transaction
+func (port *Port) Serve(bus Instance, message []parser.Msg) {
+	msg := message[0]
+	address, data := port.decoder.Transaction(message)
+	base, _ := port.device.Range()
+
+	if msg.Signal == WRITE {
+		port.device.Write(address-base, data)
+		port.reply(bus, msg, []parser.Msg{parser.Msg{Type: parser.MEMORY}})
+	} else if msg.Signal == READ {
+		port.reply(bus, msg, port.device.Read(address-base))
+	}
+}
+
+// reply answers a request once the latency of the device elapses
+func (port *Port) reply(bus Instance, request parser.Msg, payload []parser.Msg) {
+	answer := parser.Keyed(request.Key, payload)
+
+	if port.latency <= 0 {
+		bus.SendTo(request.Origin, port.device.Name(), REPLY, answer)
+		return
+	}
+
+	kernel := bus.Kernel()
+	port.schedule(bus, Reply{Cycle: kernel.Cycle() + port.latency, Origin: request.Origin, Payload: answer}, func(action func()) int {
+		return kernel.Schedule(port.latency, action)
+	})
+}
+
+// schedule sends a reply on its cycle, keeping it until it is sent
+func (port *Port) schedule(bus Instance, reply Reply, at func(func()) int) {
+	var order int
+
+	order = at(func() {
+		delete(port.replies, order)
+		bus.SendTo(reply.Origin, port.device.Name(), REPLY, reply.Payload)
+	})
+
+	reply.Order = order
+	port.replies[order] = reply
+}
+
+// Snapshot returns the state of the port, the replies are sorted by the
+// order they will be sent in
+func (port *Port) Snapshot() PortSnapshot {
+	replies := make([]Reply, 0, len(port.replies))
+	for _, reply := range port.replies {
+		replies = append(replies, reply)
+	}
+
+	sort.Slice(replies, func(i int, j int) bool {
+		return replies[i].Order < replies[j].Order
+	})
+
+	return PortSnapshot{Queue: append([]parser.Msg{}, port.queue...), Replies: replies}
+}
+
+// LoadSnapshot moves the port to a saved state, the replies are scheduled
+// again on the kernel of the bus, which must be restored first
+func (port *Port) LoadSnapshot(bus Instance, snapshot PortSnapshot) {
+	port.queue = append([]parser.Msg{}, snapshot.Queue...)
+	port.replies = make(map[int]Reply)
+
+	for _, reply := range snapshot.Replies {
+		saved := reply
+		port.schedule(bus, saved, func(action func()) int {
+			bus.Kernel().ScheduleAt(saved.Cycle, saved.Order, action)
+			return saved.Order
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package bus
+
+// Snapshot is the state of the bus, the messages buffered and the ones
+// waiting in the channels to be received
+type Snapshot struct {
+	Buffer       []Message
+	Channels     map[string][]Message
+	Owner        string
+	Transactions int
+	Started      map[int]int
+	Masters      []string
+	Stats        Stats
+}
+
+// Message is a message of the bus on its way to a lane of a channel
+type Message struct {
+	Channel     string
+	Destination string
+	Lane        string
+	Since       int
+	Transaction int
+	Action      Action
+}
+
+func export(el msg) Message {
+	return Message{Channel: el.channel, Destination: el.destination, Lane: el.lane, Since: el.since, Transaction: el.transaction, Action: el.action}
+}
+
+func (message Message) restore() msg {
+	return msg{channel: message.Channel, destination: message.Destination, lane: message.Lane, since: message.Since, transaction: message.Transaction, action: message.Action}
+}
+
+// Snapshot returns the state of the bus
+func (bus *bus) Snapshot() Snapshot {
+	stats := bus.Stats()
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	snapshot := Snapshot{
+		Buffer:       make([]Message, 0, bus.buffer.Len()),
+		Channels:     make(map[string][]Message),
+		Owner:        bus.owner,
+		Transactions: bus.transactions,
+		Started:      make(map[int]int),
+		Masters:      append([]string{}, bus.masters...),
+		Stats:        stats,
+	}
+
+	for transaction, left := range bus.started {
+		snapshot.Started[transaction] = left
+	}
+
+	for front := bus.buffer.Front(); front != nil; front = front.Next() {
+		snapshot.Buffer = append(snapshot.Buffer, export(front.Value.(msg)))
+	}
+
+	for channel, msgs := range bus.channels {
+		snapshot.Channels[channel] = make([]Message, len(msgs))
+
+		for index, el := range msgs {
+			snapshot.Channels[channel][index] = export(el)
+		}
+	}
+
+	return snapshot
+}
+
+// LoadSnapshot moves the bus to a saved state
+func (bus *bus) LoadSnapshot(snapshot Snapshot) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.buffer.Init()
+	for _, message := range snapshot.Buffer {
+		bus.buffer.PushBack(message.restore())
+	}
+
+	bus.channels = make(map[string][]msg)
+	for channel, messages := range snapshot.Channels {
+		bus.channels[channel] = make([]msg, len(messages))
+
+		for index, message := range messages {
+			bus.channels[channel][index] = message.restore()
+		}
+	}
+
+	bus.owner = snapshot.Owner
+	bus.transactions = snapshot.Transactions
+	bus.started = make(map[int]int)
+	for transaction, left := range snapshot.Started {
+		bus.started[transaction] = left
+	}
+
+	bus.masters = append([]string{}, snapshot.Masters...)
+	bus.stats = snapshot.Stats
+
+	if bus.stats.Masters == nil {
+		bus.stats.Masters = make(map[string]Usage)
+	}
+
+	if bus.stats.Lanes == nil {
+		bus.stats.Lanes = make(map[string]Usage)
+	}
+}
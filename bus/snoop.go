@@ -0,0 +1,80 @@
+package bus
+
+// This constants are the coherence transactions broadcast by a cache to
+// the others, for the line being accessed
+const (
+	// BUSRD reads a line, the copies being modified are written back and
+	// shared
+	BUSRD = iota
+	// BUSRDX writes a line missing the cache, the other copies are
+	// written back and invalidated
+	BUSRDX
+	// BUSUPGR writes a shared line, the other copies are invalidated
+	BUSUPGR
+)
+
+// Snoop is the answer of a cache to a coherence transaction
+type Snoop struct {
+	// Shared tells if the cache holds the line
+	Shared bool
+	// Pending tells if a store to the line is in flight, the line read
+	// must not be kept until it is acknowledged
+	Pending bool
+	// Invalidated tells if the cache dropped the line
+	Invalidated bool
+	// Flushed tells if the cache wrote back the dirty words of the line
+	Flushed bool
+}
+
+// Snooper is a cache observing the coherence transactions of the others
+type Snooper interface {
+	Name() string
+	Snoop(int, int, int) Snoop
+}
+
+// Observe attaches a cache to the coherence transactions
+func (bus *bus) Observe(snooper Snooper) {
+	bus.snoopers = append(bus.snoopers, snooper)
+}
+
+// Broadcast sends a coherence transaction for the words of a line to the
+// caches of the other origins, merging their answers. The dirty words are
+// written back straight to the memory while the transaction is snooped.
+// The transactions no other cache snooped are not counted
+func (bus *bus) Broadcast(origin string, signal int, base int, size int) Snoop {
+	answer := Snoop{}
+	snooped, invalidations, flushes := 0, 0, 0
+
+	for _, snooper := range bus.snoopers {
+		if snooper.Name() == origin {
+			continue
+		}
+
+		snooped++
+		reply := snooper.Snoop(signal, base, size)
+
+		answer.Shared = answer.Shared || reply.Shared
+		answer.Pending = answer.Pending || reply.Pending
+
+		if reply.Invalidated {
+			invalidations++
+		}
+
+		if reply.Flushed {
+			flushes++
+		}
+	}
+
+	if snooped == 0 {
+		return answer
+	}
+
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.stats.Coherence++
+	bus.stats.Invalidations += invalidations
+	bus.stats.Flushes += flushes
+
+	return answer
+}
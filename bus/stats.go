@@ -0,0 +1,98 @@
+package bus
+
+// Usage holds the counters of a master or of a lane of the bus
+type Usage struct {
+	// Messages is the number of messages transferred
+	Messages int
+	Bytes    int
+	// Busy is the number of transfers carrying any message
+	Busy int
+	// Wait is the number of cycles the messages transferred waited in the
+	// buffer
+	Wait int
+	// Depth is the sum of the messages waiting in the buffer at every
+	// transfer, MaxDepth is the most messages waiting at once
+	Depth    int
+	MaxDepth int
+}
+
+// Utilization returns the fraction of the transfers carrying any message
+func (usage Usage) Utilization(transfers int) float64 {
+	if transfers == 0 {
+		return 0
+	}
+
+	return float64(usage.Busy) / float64(transfers)
+}
+
+// AverageWait returns the cycles waited by a message on average
+func (usage Usage) AverageWait() float64 {
+	if usage.Messages == 0 {
+		return 0
+	}
+
+	return float64(usage.Wait) / float64(usage.Messages)
+}
+
+// AverageDepth returns the messages waiting in the buffer on average
+func (usage Usage) AverageDepth(transfers int) float64 {
+	if transfers == 0 {
+		return 0
+	}
+
+	return float64(usage.Depth) / float64(transfers)
+}
+
+// sample counts the messages waiting for the transfer about to start
+func (bus *bus) sample() {
+	masters := make(map[string]int)
+	channels := make(map[string]int)
+
+	for front := bus.buffer.Front(); front != nil; front = front.Next() {
+		el := front.Value.(msg)
+		masters[el.action.Origin]++
+		channels[el.lane]++
+	}
+
+	for _, master := range bus.masters {
+		bus.stats.Masters[master] = deepen(bus.stats.Masters[master], masters[master])
+	}
+
+	for _, lane := range lanes {
+		bus.stats.Lanes[lane] = deepen(bus.stats.Lanes[lane], channels[lane])
+	}
+}
+
+func deepen(usage Usage, depth int) Usage {
+	usage.Depth += depth
+	if depth > usage.MaxDepth {
+		usage.MaxDepth = depth
+	}
+
+	return usage
+}
+
+// account counts a message transferred, the masters and lanes in busy
+// already transferred during this transfer
+func (bus *bus) account(el msg, busy map[string]bool) {
+	wait := bus.kernel.Cycle() - el.since
+	bytes := len(el.action.Payload)
+
+	bus.stats.Masters[el.action.Origin] = use(bus.stats.Masters[el.action.Origin], bytes, wait, !busy[el.action.Origin])
+	bus.stats.Lanes[el.lane] = use(bus.stats.Lanes[el.lane], bytes, wait, !busy[el.lane])
+
+	busy[el.action.Origin] = true
+	busy[el.lane] = true
+}
+
+func use(usage Usage, bytes int, wait int, first bool) Usage {
+	usage.Messages++
+	usage.Bytes += bytes
+	usage.Wait += wait
+
+	if first {
+		usage.Busy++
+	}
+
+	return usage
+}
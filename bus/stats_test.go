@@ -0,0 +1,166 @@
+package bus
+
+import (
+	"testing"
+
+	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/sim"
+)
+
+// recorder keeps the events of the bus in memory
+type recorder struct {
+	events []Event
+}
+
+func (recorder *recorder) Record(event Event) {
+	recorder.events = append(recorder.events, event)
+}
+
+func (recorder *recorder) Close() error {
+	return nil
+}
+
+// fixed runs a 16 bits bus for three transfers. The core 0 writes three
+// words to the memory, two of them fit in the first transfer, and the
+// core 1 reads an address
+func fixed(policy int, tracers ...Tracer) Instance {
+	kernel := sim.New(1)
+	bus := New(kernel, 16, 1, NewArbiter(policy))
+
+	bus.MakeChannel("cpu0")
+	bus.MakeChannel("cpu1")
+
+	for _, tracer := range tracers {
+		bus.Trace(tracer)
+	}
+
+	bus.SendTo("memory", "cpu0", WRITE, []parser.Msg{
+		{Key: 1, Type: parser.LITERAL, Value: 7},
+		{Key: 2, Type: parser.LITERAL, Value: 8},
+		{Key: 3, Type: parser.LITERAL, Value: 9},
+	})
+	bus.SendTo("memory", "cpu1", READ, []parser.Msg{{Key: 4, Type: parser.MEMORY, Value: 6}})
+
+	bus.Run()
+	kernel.Schedule(3, kernel.Stop)
+	kernel.Run()
+
+	return bus
+}
+
+func TestStats(t *testing.T) {
+	stats := fixed(FIFO).Stats()
+
+	if stats.Reads != 1 || stats.Writes != 1 || stats.Replies != 0 || stats.Transfers != 3 {
+		t.Errorf("stats %+v, want 1 read, 1 write and 3 transfers", stats)
+	}
+
+	usages := map[string]map[string]Usage{
+		"master": {
+			"cpu0": {Messages: 2, Bytes: 3, Busy: 2, Wait: 1, Depth: 3, MaxDepth: 2},
+			"cpu1": {Messages: 1, Bytes: 1, Busy: 1, Wait: 0, Depth: 1, MaxDepth: 1},
+		},
+		"lane": {
+			DATA:       {Messages: 2, Bytes: 3, Busy: 2, Wait: 1, Depth: 3, MaxDepth: 2},
+			ADDRESS:    {Messages: 1, Bytes: 1, Busy: 1, Wait: 0, Depth: 1, MaxDepth: 1},
+			INSTUCTION: {},
+		},
+	}
+
+	for kind, usage := range usages {
+		got := stats.Masters
+		if kind == "lane" {
+			got = stats.Lanes
+		}
+
+		for name, want := range usage {
+			if got[name] != want {
+				t.Errorf("%s %s: usage %+v, want %+v", kind, name, got[name], want)
+			}
+		}
+	}
+
+	cpu0 := stats.Masters["cpu0"]
+	if utilization := cpu0.Utilization(stats.Transfers); utilization < 0.66 || utilization > 0.67 {
+		t.Errorf("cpu0: utilization %.2f, want two thirds", utilization)
+	}
+
+	if wait := cpu0.AverageWait(); wait != 0.5 {
+		t.Errorf("cpu0: average wait %.2f, want 0.5", wait)
+	}
+
+	if depth := cpu0.AverageDepth(stats.Transfers); depth != 1 {
+		t.Errorf("cpu0: average depth %.2f, want 1", depth)
+	}
+}
+
+// TestContention checks the cycle every word is delivered on with every
+// policy, only the TDMA holds the core 1 back for the core 0
+func TestContention(t *testing.T) {
+	tests := []struct {
+		policy    int
+		delivered map[int]int
+	}{
+		{FIFO, map[int]int{7: 0, 9: 1, 6: 0}},
+		{ROUNDROBIN, map[int]int{7: 0, 9: 1, 6: 0}},
+		{PRIORITY, map[int]int{7: 0, 9: 1, 6: 0}},
+		{TDMA, map[int]int{7: 0, 9: 2, 6: 1}},
+	}
+
+	for _, test := range tests {
+		events := &recorder{}
+		fixed(test.policy, events)
+
+		delivered := make(map[int]int)
+		for _, event := range events.events {
+			if event.Kind == DELIVER {
+				delivered[event.Payload[0]] = event.Cycle
+			}
+		}
+
+		for word, cycle := range test.delivered {
+			if got, ok := delivered[word]; !ok || got != cycle {
+				t.Errorf("policy %d: word %d delivered on cycle %d, want %d", test.policy, word, got, cycle)
+			}
+		}
+	}
+}
+
+// snooper answers the coherence transactions with the same snoop
+type snooper struct {
+	name  string
+	reply Snoop
+}
+
+func (snooper snooper) Name() string {
+	return snooper.name
+}
+
+func (snooper snooper) Snoop(signal int, base int, size int) Snoop {
+	return snooper.reply
+}
+
+// TestBroadcast counts the coherence transactions snooped by the other
+// caches, a single core broadcasts to nobody
+func TestBroadcast(t *testing.T) {
+	bus := New(sim.New(1), 16, 1, NewArbiter(FIFO))
+	bus.Observe(snooper{name: "cpu0"})
+
+	if answer := bus.Broadcast("cpu0", BUSRD, 0, 4); answer != (Snoop{}) {
+		t.Errorf("a single core was answered %+v", answer)
+	}
+
+	if stats := bus.Stats(); stats.Coherence != 0 {
+		t.Errorf("a single core counted %d coherence transactions", stats.Coherence)
+	}
+
+	bus.Observe(snooper{name: "cpu1", reply: Snoop{Shared: true, Invalidated: true, Flushed: true}})
+
+	if answer := bus.Broadcast("cpu0", BUSRDX, 0, 4); !answer.Shared {
+		t.Errorf("the line held by the core 1 was answered %+v", answer)
+	}
+
+	if stats := bus.Stats(); stats.Coherence != 1 || stats.Invalidations != 1 || stats.Flushes != 1 {
+		t.Errorf("stats %+v, want a coherence transaction invalidating and flushing a line", stats)
+	}
+}
@@ -0,0 +1,172 @@
+package bus
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// This constants are the formats of the trace files
+const (
+	// JSONLINES writes an object per line
+	JSONLINES = iota
+	// CSV writes a header followed by a row per event
+	CSV
+)
+
+var formats = map[string]int{
+	"jsonl": JSONLINES,
+	"csv":   CSV,
+}
+
+// ParseTraceFormat returns the trace format with the given name
+func ParseTraceFormat(name string) (int, bool) {
+	format, ok := formats[name]
+	return format, ok
+}
+
+// This constants are the kinds of the traced events
+const (
+	// ENQUEUE is recorded when a message is buffered by SendTo
+	ENQUEUE = "enqueue"
+	// DELIVER is recorded when a message is moved into its channel
+	DELIVER = "deliver"
+)
+
+var signalNames = []string{"READ", "WRITE", "REPLY"}
+
+// Event is a message traveling on the bus
+type Event struct {
+	Cycle       int    `json:"cycle"`
+	Kind        string `json:"event"`
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+	Lane        string `json:"lane"`
+	Signal      string `json:"signal"`
+	Key         int    `json:"key"`
+	Payload     []int  `json:"payload"`
+}
+
+// Tracer records the events of the bus
+type Tracer interface {
+	Record(Event)
+	Close() error
+}
+
+// Trace attaches a tracer to the bus, every message is recorded when it is
+// buffered and when it is delivered
+func (bus *bus) Trace(tracer Tracer) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.tracers = append(bus.tracers, tracer)
+}
+
+func (bus *bus) record(kind string, el msg) {
+	if len(bus.tracers) == 0 {
+		return
+	}
+
+	event := Event{
+		Cycle:       bus.kernel.Cycle(),
+		Kind:        kind,
+		Origin:      el.action.Origin,
+		Destination: el.destination,
+		Lane:        el.lane,
+		Signal:      signalName(el.action.Signal),
+		Payload:     make([]int, 0),
+	}
+
+	for index, message := range el.action.Payload {
+		if index == 0 {
+			event.Key = message.Key
+		}
+
+		event.Payload = append(event.Payload, int(message.Value))
+	}
+
+	for _, tracer := range bus.tracers {
+		tracer.Record(event)
+	}
+}
+
+func signalName(signal int) string {
+	if signal < 0 || signal >= len(signalNames) {
+		return strconv.Itoa(signal)
+	}
+
+	return signalNames[signal]
+}
+
+type tracer struct {
+	file    *os.File
+	writer  *bufio.Writer
+	json    *json.Encoder
+	csv     *csv.Writer
+	written bool
+}
+
+// NewTracer returns a tracer writing the events to a file in the given
+// format
+func NewTracer(path string, format int) (Tracer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := bufio.NewWriter(file)
+	tracer := &tracer{file: file, writer: writer}
+
+	if format == CSV {
+		tracer.csv = csv.NewWriter(writer)
+	} else {
+		tracer.json = json.NewEncoder(writer)
+	}
+
+	return tracer, nil
+}
+
+func (tracer *tracer) Record(event Event) {
+	if tracer.json != nil {
+		tracer.json.Encode(event)
+		return
+	}
+
+	if !tracer.written {
+		tracer.csv.Write([]string{"cycle", "event", "origin", "destination", "lane", "signal", "key", "payload"})
+		tracer.written = true
+	}
+
+	payload := make([]string, len(event.Payload))
+	for index, value := range event.Payload {
+		payload[index] = strconv.Itoa(value)
+	}
+
+	tracer.csv.Write([]string{
+		strconv.Itoa(event.Cycle),
+		event.Kind,
+		event.Origin,
+		event.Destination,
+		event.Lane,
+		event.Signal,
+		strconv.Itoa(event.Key),
+		strings.Join(payload, " "),
+	})
+}
+
+// Close flushes the events recorded and closes the file
+func (tracer *tracer) Close() error {
+	if tracer.csv != nil {
+		tracer.csv.Flush()
+	}
+
+	if err := tracer.writer.Flush(); err != nil {
+		tracer.file.Close()
+		return err
+	}
+
+	return tracer.file.Close()
+}
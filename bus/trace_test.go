@@ -0,0 +1,77 @@
+package bus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// traced returns the file written by a tracer of the fixed run
+func traced(t *testing.T, create func(string) Tracer) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "trace")
+	tracer := create(path)
+	fixed(FIFO, tracer)
+
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("could not close the tracer: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("could not read the trace: %v", err)
+	}
+
+	return string(data)
+}
+
+func TestTrace(t *testing.T) {
+	tests := []struct {
+		format int
+		want   string
+	}{
+		{JSONLINES, `{"cycle":0,"event":"enqueue","origin":"cpu0","destination":"memory","lane":"Data","signal":"WRITE","key":1,"payload":[7,8]}
+{"cycle":0,"event":"enqueue","origin":"cpu0","destination":"memory","lane":"Data","signal":"WRITE","key":3,"payload":[9]}
+{"cycle":0,"event":"enqueue","origin":"cpu1","destination":"memory","lane":"Address","signal":"READ","key":4,"payload":[6]}
+{"cycle":0,"event":"deliver","origin":"cpu0","destination":"memory","lane":"Data","signal":"WRITE","key":1,"payload":[7,8]}
+{"cycle":0,"event":"deliver","origin":"cpu1","destination":"memory","lane":"Address","signal":"READ","key":4,"payload":[6]}
+{"cycle":1,"event":"deliver","origin":"cpu0","destination":"memory","lane":"Data","signal":"WRITE","key":3,"payload":[9]}
+`},
+		{CSV, `cycle,event,origin,destination,lane,signal,key,payload
+0,enqueue,cpu0,memory,Data,WRITE,1,7 8
+0,enqueue,cpu0,memory,Data,WRITE,3,9
+0,enqueue,cpu1,memory,Address,READ,4,6
+0,deliver,cpu0,memory,Data,WRITE,1,7 8
+0,deliver,cpu1,memory,Address,READ,4,6
+1,deliver,cpu0,memory,Data,WRITE,3,9
+`},
+	}
+
+	for _, test := range tests {
+		got := traced(t, func(path string) Tracer {
+			tracer, err := NewTracer(path, test.format)
+			if err != nil {
+				t.Fatalf("could not create the tracer: %v", err)
+			}
+
+			return tracer
+		})
+
+		if got != test.want {
+			t.Errorf("format %d: trace\n%s\nwant\n%s", test.format, got, test.want)
+		}
+	}
+}
+
+func TestParseTraceFormat(t *testing.T) {
+	for name, format := range map[string]int{"jsonl": JSONLINES, "csv": CSV} {
+		if parsed, ok := ParseTraceFormat(name); !ok || parsed != format {
+			t.Errorf("ParseTraceFormat(%q) = %d, %v, want %d", name, parsed, ok, format)
+		}
+	}
+
+	if _, ok := ParseTraceFormat("xml"); ok {
+		t.Errorf("ParseTraceFormat accepted an unknown format")
+	}
+}
@@ -0,0 +1,234 @@
+package bus
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// signal is a wire of the waveform
+type signal struct {
+	id    string
+	width int
+}
+
+type vcd struct {
+	path      string
+	length    int
+	frequency int
+	events    []Event
+}
+
+// NewVCD returns a tracer writing the messages delivered by the bus as a
+// Value Change Dump, once it is closed. Every channel has a wire per lane,
+// as wide as the bus, carrying the bytes delivered during a cycle and high
+// impedance otherwise, along with READ and WRITE strobes. The clock of the
+// machine at the given frequency in MHz comes first
+func NewVCD(path string, length int, frequency int) Tracer {
+	if frequency < 1 {
+		frequency = 1
+	}
+
+	return &vcd{
+		path:      path,
+		length:    length,
+		frequency: frequency,
+		events:    make([]Event, 0),
+	}
+}
+
+func (vcd *vcd) Record(event Event) {
+	if event.Kind == DELIVER {
+		vcd.events = append(vcd.events, event)
+	}
+}
+
+// Close writes the waveform
+func (vcd *vcd) Close() error {
+	file, err := os.Create(vcd.path)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	signals := vcd.declare(writer)
+	vcd.dump(writer, signals)
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// declare writes the header, returning the wires by name. The wires of a
+// channel are named after it, the strobes after the signals
+func (vcd *vcd) declare(writer *bufio.Writer) map[string]signal {
+	channels := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, event := range vcd.events {
+		if !seen[event.Destination] {
+			seen[event.Destination] = true
+			channels = append(channels, event.Destination)
+		}
+	}
+
+	sort.Strings(channels)
+
+	signals := make(map[string]signal)
+	next := 0
+
+	declare := func(name string, label string, width int) {
+		signals[name] = signal{id: identifier(next), width: width}
+		next++
+		fmt.Fprintf(writer, "$var wire %d %s %s $end\n", width, signals[name].id, label)
+	}
+
+	fmt.Fprintln(writer, "$version cpu-emulator $end")
+	fmt.Fprintln(writer, "$timescale 1ps $end")
+	fmt.Fprintln(writer, "$scope module bus $end")
+	declare("clock", "clock", 1)
+
+	for _, channel := range channels {
+		fmt.Fprintf(writer, "$scope module %s $end\n", channel)
+
+		for _, lane := range lanes {
+			declare(channel+lane, lane, vcd.length)
+		}
+
+		for _, strobe := range signalNames[:REPLY] {
+			declare(channel+strobe, strobe, 1)
+		}
+
+		fmt.Fprintln(writer, "$upscope $end")
+	}
+
+	fmt.Fprintln(writer, "$upscope $end")
+	fmt.Fprintln(writer, "$enddefinitions $end")
+
+	return signals
+}
+
+// dump writes the changes of the wires, twice per cycle for the clock
+func (vcd *vcd) dump(writer *bufio.Writer, signals map[string]signal) {
+	half := 500000 / vcd.frequency
+	if half < 1 {
+		half = 1
+	}
+
+	last := 0
+	if len(vcd.events) > 0 {
+		last = vcd.events[len(vcd.events)-1].Cycle + 1
+	}
+
+	values := make(map[string]string)
+	next := 0
+
+	for cycle := 0; cycle <= last; cycle++ {
+		current := make(map[string]string)
+		for name, wire := range signals {
+			current[name] = idle(wire.width)
+		}
+
+		bytes := make(map[string][]int)
+		for ; next < len(vcd.events) && vcd.events[next].Cycle == cycle; next++ {
+			event := vcd.events[next]
+			bytes[event.Destination+event.Lane] = append(bytes[event.Destination+event.Lane], event.Payload...)
+			if _, ok := signals[event.Destination+event.Signal]; ok {
+				current[event.Destination+event.Signal] = "1"
+			}
+		}
+
+		for name, payload := range bytes {
+			current[name] = binary(payload, signals[name].width)
+		}
+
+		current["clock"] = "1"
+
+		fmt.Fprintf(writer, "#%d\n", cycle*2*half)
+		if cycle == 0 {
+			fmt.Fprintln(writer, "$dumpvars")
+		}
+
+		names := make([]string, 0, len(current))
+		for name := range current {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			if values[name] != current[name] {
+				change(writer, signals[name], current[name])
+				values[name] = current[name]
+			}
+		}
+
+		if cycle == 0 {
+			fmt.Fprintln(writer, "$end")
+		}
+
+		fmt.Fprintf(writer, "#%d\n", (cycle*2+1)*half)
+		change(writer, signals["clock"], "0")
+		values["clock"] = "0"
+	}
+}
+
+func change(writer *bufio.Writer, wire signal, value string) {
+	if wire.width == 1 {
+		fmt.Fprintf(writer, "%s%s\n", value, wire.id)
+		return
+	}
+
+	fmt.Fprintf(writer, "b%s %s\n", value, wire.id)
+}
+
+func idle(width int) string {
+	if width == 1 {
+		return "0"
+	}
+
+	return "z"
+}
+
+// binary returns the bits of the bytes delivered in a cycle, the first one
+// is the least significant
+func binary(payload []int, width int) string {
+	bits := make([]string, 0, len(payload))
+
+	for _, value := range payload {
+		bits = append([]string{fmt.Sprintf("%08b", value&0xff)}, bits...)
+	}
+
+	value := strings.TrimLeft(strings.Join(bits, ""), "0")
+	if value == "" {
+		return "0"
+	}
+
+	if len(value) > width {
+		value = value[len(value)-width:]
+	}
+
+	return value
+}
+
+// identifier returns the short code of the n-th wire, made of the
+// printable characters
+func identifier(index int) string {
+	code := ""
+
+	for {
+		code += string(rune('!' + index%94))
+		index /= 94
+
+		if index == 0 {
+			return code
+		}
+
+		index--
+	}
+}
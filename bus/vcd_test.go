@@ -0,0 +1,80 @@
+package bus
+
+import "testing"
+
+// TestVCD writes the waveform of the fixed run at 1 MHz, every half cycle
+// lasts 500000 ps
+func TestVCD(t *testing.T) {
+	want := `$version cpu-emulator $end
+$timescale 1ps $end
+$scope module bus $end
+$var wire 1 ! clock $end
+$scope module memory $end
+$var wire 16 " Address $end
+$var wire 16 # Data $end
+$var wire 16 $ Instruction $end
+$var wire 1 % READ $end
+$var wire 1 & WRITE $end
+$upscope $end
+$upscope $end
+$enddefinitions $end
+#0
+$dumpvars
+1!
+b110 "
+b100000000111 #
+bz $
+1%
+1&
+$end
+#500000
+0!
+#1000000
+1!
+bz "
+b1001 #
+0%
+#1500000
+0!
+#2000000
+1!
+bz #
+0&
+#2500000
+0!
+`
+
+	got := traced(t, func(path string) Tracer {
+		return NewVCD(path, 16, 1)
+	})
+
+	if got != want {
+		t.Errorf("waveform\n%s\nwant\n%s", got, want)
+	}
+}
+
+// TestVCDFrequency clamps the frequencies below 1 MHz, the clock of a
+// machine at 0 MHz is the one at 1 MHz
+func TestVCDFrequency(t *testing.T) {
+	for _, frequency := range []int{0, -5} {
+		got := traced(t, func(path string) Tracer {
+			return NewVCD(path, 16, frequency)
+		})
+
+		want := traced(t, func(path string) Tracer {
+			return NewVCD(path, 16, 1)
+		})
+
+		if got != want {
+			t.Errorf("frequency %d: waveform\n%s\nwant the one at 1 MHz", frequency, got)
+		}
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	for index, want := range map[int]string{0: "!", 1: "\"", 93: "~", 94: "!!", 95: "\"!", 188: "!\""} {
+		if got := identifier(index); got != want {
+			t.Errorf("identifier(%d) = %q, want %q", index, got, want)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package cache
+
+import (
+	"math/rand"
+
+	"github.com/bruunoromero/cpu-emulator/utils"
+)
+
+// This constants are the replacement policies, choosing the line evicted
+// from a full set
+const (
+	// LRU evicts the line used the longest time ago
+	LRU = iota
+	// LFU evicts the line used the fewest times
+	LFU
+	// FIFO evicts the line filled the longest time ago
+	FIFO
+	// RANDOM evicts any line of the set
+	RANDOM
+)
+
+// This constants are the write policies, the stores missing the cache are
+// written around it with both
+const (
+	// WRITEBACK keeps the stores in the cache until the line is evicted
+	// or flushed
+	WRITEBACK = iota
+	// WRITETHROUGH sends every store to the memory as well
+	WRITETHROUGH
+)
+
+var replacements = map[string]int{
+	"lru":    LRU,
+	"lfu":    LFU,
+	"fifo":   FIFO,
+	"random": RANDOM,
+}
+
+var writes = map[string]int{
+	"back":    WRITEBACK,
+	"through": WRITETHROUGH,
+}
+
+// Config describes the geometry and the policies of a cache, sizes are
+// given in words
+type Config struct {
+	Size          int
+	LineSize      int
+	Associativity int
+	Replacement   int
+	Write         int
+	// Latency is the number of cycles of a lookup
+	Latency int
+}
+
+// Stats holds the counters of a cache
+type Stats struct {
+	Reads      int
+	Writes     int
+	Hits       int
+	Misses     int
+	Evictions  int
+	Writebacks int
+}
+
+// HitRate returns the fraction of the accesses served by the cache
+func (stats Stats) HitRate() float64 {
+	if stats.Hits+stats.Misses == 0 {
+		return 0
+	}
+
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+// Victim is a line leaving the cache, only its dirty words must be written
+// back to the memory
+type Victim struct {
+	Base  int
+	Words []int
+	Dirty []bool
+}
+
+// Line is a copy of a line held by the cache, for inspection
+type Line struct {
+	Set   int
+	Way   int
+	Base  int
+	State int
+	Words []int
+	Dirty []bool
+}
+
+type line struct {
+	valid  bool
+	state  int
+	tag    int
+	used   int
+	filled int
+	count  int
+	words  []int
+	dirty  []bool
+}
+
+type cache struct {
+	config Config
+	clock  int
+	sets   [][]line
+	stats  Stats
+	random *rand.Rand
+	draws  int
+}
+
+// Snapshot is the state of a cache. The random replacement is restored by
+// drawing again as many victims as were drawn
+type Snapshot struct {
+	Clock int
+	Draws int
+	Sets  [][]Way
+	Stats Stats
+}
+
+// Way is the state of a line of a set
+type Way struct {
+	Valid  bool
+	State  int
+	Tag    int
+	Used   int
+	Filled int
+	Count  int
+	Words  []int
+	Dirty  []bool
+}
+
+// Instance is the interface of the cache type. The addresses are word
+// addresses of the bus
+type Instance interface {
+	Read(int) (int, bool)
+	Write(int, int) bool
+	Peek(int) (int, bool)
+	Poke(int, int) bool
+	Fill(int, []int) (Victim, bool)
+	Flush() []Victim
+	Base(int) int
+	LineSize() int
+	Latency() int
+	Policy() int
+	Stats() Stats
+	State(int) int
+	Mark(int, int)
+	Clean(int) (Victim, bool)
+	Invalidate(int) (Victim, bool)
+	Lines() []Line
+	Snapshot() Snapshot
+	LoadSnapshot(Snapshot)
+}
+
+// New returns a new instance of cache
+func New(config Config) Instance {
+	if config.LineSize < 1 || config.Associativity < 1 {
+		utils.Abort("Cannot instanciate cache with this geometry")
+	}
+
+	count := config.Size / (config.LineSize * config.Associativity)
+	if count < 1 {
+		utils.Abort("Cannot instanciate cache with this geometry")
+	}
+
+	sets := make([][]line, count)
+	for index := range sets {
+		sets[index] = make([]line, config.Associativity)
+	}
+
+	return &cache{
+		config: config,
+		sets:   sets,
+		random: rand.New(rand.NewSource(1)),
+	}
+}
+
+func (cache *cache) Base(address int) int {
+	return address - address%cache.config.LineSize
+}
+
+func (cache *cache) LineSize() int {
+	return cache.config.LineSize
+}
+
+func (cache *cache) Latency() int {
+	return cache.config.Latency
+}
+
+func (cache *cache) Policy() int {
+	return cache.config.Write
+}
+
+func (cache *cache) Stats() Stats {
+	return cache.stats
+}
+
+// Read returns the word at an address, counting the access
+func (cache *cache) Read(address int) (int, bool) {
+	cache.stats.Reads++
+
+	line := cache.lookup(address)
+	if line == nil {
+		cache.stats.Misses++
+		return 0, false
+	}
+
+	cache.stats.Hits++
+	cache.touch(line)
+
+	return line.words[cache.offset(address)], true
+}
+
+// Peek returns the word at an address without counting the access
+func (cache *cache) Peek(address int) (int, bool) {
+	line := cache.lookup(address)
+	if line == nil {
+		return 0, false
+	}
+
+	return line.words[cache.offset(address)], true
+}
+
+// Poke updates the word at an address when its line is cached, without
+// counting the access nor marking the word dirty
+func (cache *cache) Poke(address int, value int) bool {
+	line := cache.lookup(address)
+	if line == nil {
+		return false
+	}
+
+	line.words[cache.offset(address)] = value
+
+	return true
+}
+
+// Write updates the word at an address when its line is cached. With a
+// write-back policy the word is marked dirty
+func (cache *cache) Write(address int, value int) bool {
+	cache.stats.Writes++
+
+	line := cache.lookup(address)
+	if line == nil {
+		cache.stats.Misses++
+		return false
+	}
+
+	cache.stats.Hits++
+	cache.touch(line)
+
+	offset := cache.offset(address)
+	line.words[offset] = value
+
+	if cache.config.Write == WRITEBACK {
+		line.dirty[offset] = true
+	}
+
+	return true
+}
+
+// Fill stores the words of the line starting at base, returning the line
+// evicted to make room when it has dirty words
+func (cache *cache) Fill(base int, words []int) (Victim, bool) {
+	if cache.lookup(base) != nil {
+		return Victim{}, false
+	}
+
+	set := cache.sets[cache.index(base)]
+	slot := &set[cache.replace(set)]
+
+	victim, dirty := Victim{}, false
+	if slot.valid {
+		cache.stats.Evictions++
+		victim, dirty = cache.evict(slot, cache.index(base))
+	}
+
+	cache.clock++
+	*slot = line{
+		valid:  true,
+		state:  SHARED,
+		tag:    cache.tag(base),
+		used:   cache.clock,
+		filled: cache.clock,
+		count:  1,
+		words:  append([]int{}, words...),
+		dirty:  make([]bool, cache.config.LineSize),
+	}
+
+	return victim, dirty
+}
+
+// Flush cleans every dirty line, returning them to be written back
+func (cache *cache) Flush() []Victim {
+	victims := make([]Victim, 0)
+
+	for index, set := range cache.sets {
+		for way := range set {
+			if !set[way].valid {
+				continue
+			}
+
+			if victim, dirty := cache.evict(&set[way], index); dirty {
+				victims = append(victims, victim)
+				set[way].dirty = make([]bool, cache.config.LineSize)
+			}
+		}
+	}
+
+	return victims
+}
+
+// Lines returns a copy of every valid line, set by set
+func (cache *cache) Lines() []Line {
+	lines := make([]Line, 0)
+
+	for index, set := range cache.sets {
+		for way, current := range set {
+			if !current.valid {
+				continue
+			}
+
+			lines = append(lines, Line{
+				Set:   index,
+				Way:   way,
+				Base:  (current.tag*len(cache.sets) + index) * cache.config.LineSize,
+				State: current.state,
+				Words: append([]int{}, current.words...),
+				Dirty: append([]bool{}, current.dirty...),
+			})
+		}
+	}
+
+	return lines
+}
+
+func (cache *cache) evict(line *line, index int) (Victim, bool) {
+	victim := Victim{
+		Base:  (line.tag*len(cache.sets) + index) * cache.config.LineSize,
+		Words: append([]int{}, line.words...),
+		Dirty: append([]bool{}, line.dirty...),
+	}
+
+	for _, dirty := range line.dirty {
+		if dirty {
+			cache.stats.Writebacks++
+			return victim, true
+		}
+	}
+
+	return victim, false
+}
+
+// replace returns the way of a set filled next, the invalid ways are used
+// before evicting any line
+func (cache *cache) replace(set []line) int {
+	for way := range set {
+		if !set[way].valid {
+			return way
+		}
+	}
+
+	if cache.config.Replacement == RANDOM {
+		cache.draws++
+		return cache.random.Intn(len(set))
+	}
+
+	chosen := 0
+	for way := range set {
+		if cache.older(set[way], set[chosen]) {
+			chosen = way
+		}
+	}
+
+	return chosen
+}
+
+// older tells if a line should be evicted before another one
+func (cache *cache) older(left line, right line) bool {
+	switch cache.config.Replacement {
+	case LFU:
+		if left.count != right.count {
+			return left.count < right.count
+		}
+
+		return left.used < right.used
+	case FIFO:
+		return left.filled < right.filled
+	default:
+		return left.used < right.used
+	}
+}
+
+func (cache *cache) touch(line *line) {
+	cache.clock++
+	line.used = cache.clock
+	line.count++
+}
+
+func (cache *cache) lookup(address int) *line {
+	set := cache.sets[cache.index(address)]
+	tag := cache.tag(address)
+
+	for way := range set {
+		if set[way].valid && set[way].tag == tag {
+			return &set[way]
+		}
+	}
+
+	return nil
+}
+
+func (cache *cache) offset(address int) int {
+	return address % cache.config.LineSize
+}
+
+func (cache *cache) index(address int) int {
+	return (address / cache.config.LineSize) % len(cache.sets)
+}
+
+func (cache *cache) tag(address int) int {
+	return (address / cache.config.LineSize) / len(cache.sets)
+}
+
+// Snapshot returns the state of the cache
+func (cache *cache) Snapshot() Snapshot {
+	sets := make([][]Way, len(cache.sets))
+
+	for index, set := range cache.sets {
+		sets[index] = make([]Way, len(set))
+
+		for way, line := range set {
+			sets[index][way] = Way{
+				Valid:  line.valid,
+				State:  line.state,
+				Tag:    line.tag,
+				Used:   line.used,
+				Filled: line.filled,
+				Count:  line.count,
+				Words:  append([]int(nil), line.words...),
+				Dirty:  append([]bool(nil), line.dirty...),
+			}
+		}
+	}
+
+	return Snapshot{Clock: cache.clock, Draws: cache.draws, Sets: sets, Stats: cache.stats}
+}
+
+// LoadSnapshot moves the cache to a saved state, the geometry must be the
+// same
+func (cache *cache) LoadSnapshot(snapshot Snapshot) {
+	for index, set := range snapshot.Sets {
+		for way, saved := range set {
+			cache.sets[index][way] = line{
+				valid:  saved.Valid,
+				state:  saved.State,
+				tag:    saved.Tag,
+				used:   saved.Used,
+				filled: saved.Filled,
+				count:  saved.Count,
+				words:  append([]int(nil), saved.Words...),
+				dirty:  append([]bool(nil), saved.Dirty...),
+			}
+		}
+	}
+
+	cache.clock = snapshot.Clock
+	cache.stats = snapshot.Stats
+	cache.random = rand.New(rand.NewSource(1))
+	cache.draws = 0
+
+	for cache.draws < snapshot.Draws {
+		cache.draws++
+		cache.random.Intn(cache.config.Associativity)
+	}
+}
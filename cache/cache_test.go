@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// TestReplacement fills a set of two ways with the words 0 and 1, reads
+// them and fills the word 2, which evicts one of them
+func TestReplacement(t *testing.T) {
+	tests := []struct {
+		name        string
+		replacement int
+		reads       []int
+		evicted     int
+	}{
+		{"lru", LRU, []int{0}, 1},
+		{"lru without reads", LRU, []int{}, 0},
+		{"lfu", LFU, []int{1, 1, 0}, 0},
+		{"lfu tie", LFU, []int{1, 0}, 1},
+		{"fifo", FIFO, []int{0}, 0},
+		{"fifo reads ignored", FIFO, []int{0, 0, 1}, 0},
+		{"random", RANDOM, []int{0}, rand.New(rand.NewSource(1)).Intn(2)},
+	}
+
+	for _, test := range tests {
+		cache := New(Config{Size: 2, LineSize: 1, Associativity: 2, Replacement: test.replacement, Write: WRITEBACK, Latency: 1})
+		cache.Fill(0, []int{10})
+		cache.Fill(1, []int{11})
+
+		for _, address := range test.reads {
+			if _, ok := cache.Read(address); !ok {
+				t.Fatalf("%s: address %d missed before the eviction", test.name, address)
+			}
+		}
+
+		cache.Fill(2, []int{12})
+
+		if _, ok := cache.Peek(test.evicted); ok {
+			t.Errorf("%s: address %d was kept, want it evicted", test.name, test.evicted)
+		}
+
+		if _, ok := cache.Peek(1 - test.evicted); !ok {
+			t.Errorf("%s: address %d was evicted, want it kept", test.name, 1-test.evicted)
+		}
+
+		if value, ok := cache.Peek(2); !ok || value != 12 {
+			t.Errorf("%s: address 2 holds %d, %v, want 12", test.name, value, ok)
+		}
+
+		if stats := cache.Stats(); stats.Evictions != 1 {
+			t.Errorf("%s: %d evictions, want 1", test.name, stats.Evictions)
+		}
+	}
+}
+
+// TestWrite writes a word of a cached line, then evicts and flushes the
+// line
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		name       string
+		write      int
+		dirty      []bool
+		writebacks int
+	}{
+		{"write-back", WRITEBACK, []bool{false, true}, 1},
+		{"write-through", WRITETHROUGH, []bool{false, false}, 0},
+	}
+
+	for _, test := range tests {
+		cache := New(Config{Size: 2, LineSize: 2, Associativity: 1, Replacement: LRU, Write: test.write, Latency: 1})
+		cache.Fill(0, []int{1, 2})
+
+		if !cache.Write(1, 5) {
+			t.Fatalf("%s: the write of a cached word missed", test.name)
+		}
+
+		if cache.Write(2, 7) {
+			t.Errorf("%s: the write of a word not cached hit", test.name)
+		}
+
+		if value, _ := cache.Peek(1); value != 5 {
+			t.Errorf("%s: address 1 holds %d, want 5", test.name, value)
+		}
+
+		victims := cache.Flush()
+		if len(victims) != test.writebacks {
+			t.Fatalf("%s: %d lines flushed, want %d", test.name, len(victims), test.writebacks)
+		}
+
+		for _, victim := range victims {
+			for offset, dirty := range test.dirty {
+				if victim.Dirty[offset] != dirty {
+					t.Errorf("%s: word %d dirty is %v, want %v", test.name, offset, victim.Dirty[offset], dirty)
+				}
+			}
+
+			if victim.Words[1] != 5 {
+				t.Errorf("%s: the flushed line holds %d, want 5", test.name, victim.Words[1])
+			}
+		}
+
+		// The flushed lines are clean, evicting them writes nothing back
+		if _, dirty := cache.Fill(2, []int{3, 4}); dirty {
+			t.Errorf("%s: the eviction of a flushed line was dirty", test.name)
+		}
+
+		stats := cache.Stats()
+		if stats.Writes != 2 || stats.Hits != 1 || stats.Misses != 1 || stats.Writebacks != test.writebacks {
+			t.Errorf("%s: stats %+v, want 2 writes, 1 hit, 1 miss and %d writebacks", test.name, stats, test.writebacks)
+		}
+	}
+}
+
+// TestEvictDirty evicts a written line with both policies, only the
+// write-back caches return it
+func TestEvictDirty(t *testing.T) {
+	for write, dirty := range map[int]bool{WRITEBACK: true, WRITETHROUGH: false} {
+		cache := New(Config{Size: 1, LineSize: 1, Associativity: 1, Replacement: LRU, Write: write, Latency: 1})
+		cache.Fill(0, []int{1})
+		cache.Write(0, 2)
+
+		victim, evicted := cache.Fill(1, []int{3})
+		if evicted != dirty {
+			t.Errorf("write policy %d: evicted dirty %v, want %v", write, evicted, dirty)
+		}
+
+		if evicted && (victim.Base != 0 || victim.Words[0] != 2) {
+			t.Errorf("write policy %d: victim %+v, want base 0 holding 2", write, victim)
+		}
+	}
+}
+
+// TestPoke updates cached words the way the debugger does, the stats and
+// the dirty words are left untouched
+func TestPoke(t *testing.T) {
+	cache := New(Config{Size: 2, LineSize: 2, Associativity: 1, Replacement: LRU, Write: WRITEBACK, Latency: 1})
+	cache.Fill(0, []int{1, 2})
+
+	if !cache.Poke(1, 5) {
+		t.Fatalf("the poke of a cached word missed")
+	}
+
+	if cache.Poke(2, 7) {
+		t.Errorf("the poke of a word not cached hit")
+	}
+
+	if value, _ := cache.Peek(1); value != 5 {
+		t.Errorf("address 1 holds %d, want 5", value)
+	}
+
+	if stats := cache.Stats(); stats != (Stats{}) {
+		t.Errorf("stats %+v, want none", stats)
+	}
+
+	if victims := cache.Flush(); len(victims) != 0 {
+		t.Errorf("%d lines flushed, want none", len(victims))
+	}
+}
@@ -0,0 +1,82 @@
+package cache
+
+// This constants are the coherence protocols keeping the caches of the
+// cores in agreement
+const (
+	// NONE leaves the caches incoherent
+	NONE = iota
+	// MSI tracks the modified, shared and invalid lines
+	MSI
+	// MESI also tracks the lines held by a single cache, which are
+	// written without telling the others
+	MESI
+)
+
+var protocols = map[string]int{
+	"none": NONE,
+	"msi":  MSI,
+	"mesi": MESI,
+}
+
+// ParseCoherence returns the coherence protocol with the given name
+func ParseCoherence(name string) (int, bool) {
+	protocol, ok := protocols[name]
+	return protocol, ok
+}
+
+// This constants are the coherence states of a line
+const (
+	// INVALID lines are not held by the cache
+	INVALID = iota
+	// SHARED lines may be held by other caches
+	SHARED
+	// EXCLUSIVE lines are only held by this cache and are clean
+	EXCLUSIVE
+	// MODIFIED lines are only held by this cache and were written
+	MODIFIED
+)
+
+// State returns the coherence state of the line holding an address
+func (cache *cache) State(address int) int {
+	line := cache.lookup(address)
+	if line == nil {
+		return INVALID
+	}
+
+	return line.state
+}
+
+// Mark changes the coherence state of the line holding an address
+func (cache *cache) Mark(address int, state int) {
+	if line := cache.lookup(address); line != nil {
+		line.state = state
+	}
+}
+
+// Clean returns the line holding an address when it has dirty words,
+// which are then considered written back
+func (cache *cache) Clean(address int) (Victim, bool) {
+	line := cache.lookup(address)
+	if line == nil {
+		return Victim{}, false
+	}
+
+	victim, dirty := cache.evict(line, cache.index(address))
+	line.dirty = make([]bool, cache.config.LineSize)
+
+	return victim, dirty
+}
+
+// Invalidate drops the line holding an address, returning it when it has
+// dirty words
+func (cache *cache) Invalidate(address int) (Victim, bool) {
+	current := cache.lookup(address)
+	if current == nil {
+		return Victim{}, false
+	}
+
+	victim, dirty := cache.evict(current, cache.index(address))
+	*current = line{}
+
+	return victim, dirty
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bruunoromero/cpu-emulator/utils"
+)
+
+// Hierarchy declares the caches between the cpu and the bus, split first
+// level caches backed by a unified second level. The levels with size 0
+// are disabled. The data levels of the cores are kept coherent with the
+// given protocol
+type Hierarchy struct {
+	Instruction Config
+	Data        Config
+	Unified     Config
+	Coherence   int
+}
+
+// Levels holds the caches built from a hierarchy, the disabled levels are
+// nil
+type Levels struct {
+	Instruction Instance
+	Data        Instance
+	Unified     Instance
+	Coherence   int
+}
+
+// Default returns the hierarchy used when none is configured
+func Default() Hierarchy {
+	return Hierarchy{
+		Instruction: Config{
+			Size:          16,
+			LineSize:      4,
+			Associativity: 2,
+			Replacement:   LRU,
+			Write:         WRITETHROUGH,
+			Latency:       1,
+		},
+		Data: Config{
+			Size:          32,
+			LineSize:      4,
+			Associativity: 2,
+			Replacement:   LRU,
+			Write:         WRITETHROUGH,
+			Latency:       1,
+		},
+		Unified: Config{
+			Size:          64,
+			LineSize:      8,
+			Associativity: 4,
+			Replacement:   LRU,
+			Write:         WRITEBACK,
+			Latency:       4,
+		},
+		Coherence: MESI,
+	}
+}
+
+// Build returns the caches declared by a hierarchy. The lines of the first
+// level must fit evenly in the lines of the second one, which refill them
+func Build(hierarchy Hierarchy) Levels {
+	levels := Levels{Coherence: hierarchy.Coherence}
+
+	if hierarchy.Unified.Size > 0 {
+		levels.Unified = New(hierarchy.Unified)
+	}
+
+	if !hierarchy.Valid() {
+		utils.Abort("Cannot instanciate cache hierarchy with this line sizes")
+	}
+
+	if hierarchy.Instruction.Size > 0 {
+		levels.Instruction = New(hierarchy.Instruction)
+	}
+
+	if hierarchy.Data.Size > 0 {
+		levels.Data = New(hierarchy.Data)
+	}
+
+	return levels
+}
+
+// Valid tells if the lines of the first level fit evenly in the lines of
+// the second one
+func (hierarchy Hierarchy) Valid() bool {
+	if hierarchy.Unified.Size == 0 {
+		return true
+	}
+
+	if hierarchy.Unified.LineSize < 1 {
+		return false
+	}
+
+	for _, config := range []Config{hierarchy.Instruction, hierarchy.Data} {
+		if config.Size > 0 && (config.LineSize < 1 || hierarchy.Unified.LineSize%config.LineSize != 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ParseConfig overrides the fields of a cache level with comma-separated
+// key=value pairs: size, line and ways in words, replacement (lru, lfu,
+// fifo or random), write (back or through) and latency in cycles. The
+// level is disabled by off, the sizes must leave room for a set
+func ParseConfig(value string, config Config) (Config, bool) {
+	if value == "" {
+		return config, true
+	}
+
+	if value == "off" {
+		config.Size = 0
+		return config, true
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return config, false
+		}
+
+		ok := false
+
+		switch parts[0] {
+		case "size":
+			config.Size, ok = parseCount(parts[1])
+		case "line":
+			config.LineSize, ok = parseCount(parts[1])
+		case "ways":
+			config.Associativity, ok = parseCount(parts[1])
+		case "replacement":
+			config.Replacement, ok = replacements[parts[1]]
+		case "write":
+			config.Write, ok = writes[parts[1]]
+		case "latency":
+			config.Latency, ok = parseCount(parts[1])
+		}
+
+		if !ok {
+			return config, false
+		}
+	}
+
+	// Every set holds at least a line of every way
+	return config, config.Size >= config.LineSize*config.Associativity
+}
+
+func parseCount(value string) (int, bool) {
+	count, err := strconv.Atoi(value)
+	return count, err == nil && count > 0
+}
@@ -0,0 +1,74 @@
+package cache
+
+import "testing"
+
+func TestParseConfig(t *testing.T) {
+	base := Config{Size: 32, LineSize: 4, Associativity: 2, Replacement: LRU, Write: WRITETHROUGH, Latency: 1}
+
+	tests := []struct {
+		value  string
+		config Config
+		ok     bool
+	}{
+		{"", base, true},
+		{"off", Config{Size: 0, LineSize: 4, Associativity: 2, Replacement: LRU, Write: WRITETHROUGH, Latency: 1}, true},
+		{"size=64,line=8", Config{Size: 64, LineSize: 8, Associativity: 2, Replacement: LRU, Write: WRITETHROUGH, Latency: 1}, true},
+		{"ways=4, replacement=lfu", Config{Size: 32, LineSize: 4, Associativity: 4, Replacement: LFU, Write: WRITETHROUGH, Latency: 1}, true},
+		{"replacement=fifo,write=back", Config{Size: 32, LineSize: 4, Associativity: 2, Replacement: FIFO, Write: WRITEBACK, Latency: 1}, true},
+		{"replacement=random,latency=3", Config{Size: 32, LineSize: 4, Associativity: 2, Replacement: RANDOM, Write: WRITETHROUGH, Latency: 3}, true},
+		{"size=8", Config{Size: 8, LineSize: 4, Associativity: 2, Replacement: LRU, Write: WRITETHROUGH, Latency: 1}, true},
+		{"size=4,line=8", base, false},
+		{"ways=16", base, false},
+		{"size=0", base, false},
+		{"latency=0", base, false},
+		{"line=-4", base, false},
+		{"size=x", base, false},
+		{"replacement=mru", base, false},
+		{"write=around", base, false},
+		{"sets=2", base, false},
+		{"size", base, false},
+		{"off,size=8", base, false},
+	}
+
+	for _, test := range tests {
+		config, ok := ParseConfig(test.value, base)
+		if ok != test.ok {
+			t.Errorf("ParseConfig(%q) ok = %v, want %v", test.value, ok, test.ok)
+			continue
+		}
+
+		if ok && config != test.config {
+			t.Errorf("ParseConfig(%q) = %+v, want %+v", test.value, config, test.config)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(*Hierarchy)
+		valid  bool
+	}{
+		{"default", func(hierarchy *Hierarchy) {}, true},
+		{"equal lines", func(hierarchy *Hierarchy) { hierarchy.Data.LineSize = 8 }, true},
+		{"first level line larger", func(hierarchy *Hierarchy) { hierarchy.Data.LineSize = 16 }, false},
+		{"first level line not dividing", func(hierarchy *Hierarchy) { hierarchy.Instruction.LineSize = 3 }, false},
+		{"first level disabled", func(hierarchy *Hierarchy) {
+			hierarchy.Instruction.Size = 0
+			hierarchy.Instruction.LineSize = 3
+		}, true},
+		{"second level disabled", func(hierarchy *Hierarchy) {
+			hierarchy.Unified.Size = 0
+			hierarchy.Data.LineSize = 16
+		}, true},
+	}
+
+	for _, test := range tests {
+		hierarchy := Default()
+		test.change(&hierarchy)
+
+		if valid := hierarchy.Valid(); valid != test.valid {
+			t.Errorf("%s: Valid() = %v, want %v", test.name, valid, test.valid)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package cpu
+
+import (
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// DATAKEYS is the first key of the data transactions, the keys below it
+// belong to the instruction fetches
+const DATAKEYS = 1 << 16
+
+// address translates a data address into a bus address. The addresses of
+// the memory-mapped I/O region are not relative to the data memory
+func (cpu *cpu) address(position int) int {
+	if position >= b.IOBASE {
+		return position
+	}
+
+	return position + cpu.memoryOffest
+}
+
+// isWaiting tells if there are loads or stores waiting for their replies
+func (cpu *cpu) isWaiting() bool {
+	return len(cpu.pending) > 0 || len(cpu.misses) > 0 || len(cpu.stores) > 0
+}
+
+func (cpu *cpu) nextKey() int {
+	key := cpu.key
+	cpu.key++
+
+	return key
+}
+
+// operands returns the data addresses read by an instruction
+func (cpu *cpu) operands(instruction parser.Action) []int {
+	positions := make([]int, 0)
+
+	for _, parameter := range instruction.Parameters {
+		if parameter.Type == parser.MEMORY {
+			positions = append(positions, parameter.Value)
+		}
+	}
+
+	location := instruction.Location
+	if location.Type != parser.MEMORY {
+		return positions
+	}
+
+	if instruction.Action == parser.Add || instruction.Action == parser.Inc || isConditional(instruction.Action) || isAtomic(instruction.Action) {
+		positions = append(positions, location.Value)
+	}
+
+	return positions
+}
+
+// load reads every operand of an instruction from the cache, issuing a
+// read transaction for the operands missing it. The atomic instructions
+// read their word from the memory
+func (cpu *cpu) load(instruction parser.Action) error {
+	for _, position := range cpu.operands(instruction) {
+		address := cpu.address(position)
+
+		if cpu.bus.Device(address) == nil {
+			return raise(interrupt.FAULT)
+		}
+
+		cpu.accessing(Access{Address: address})
+
+		if !isAtomic(instruction.Action) && cpu.cacheable(cpu.caches.Data, address) {
+			cpu.lookup(position, address)
+			continue
+		}
+
+		key := cpu.nextKey()
+		cpu.pending[key] = position
+		cpu.bus.Route(cpu.name, b.READ, address, cpu.encoder.Transaction(key, address, nil))
+	}
+
+	return nil
+}
+
+// write stores a word, updating the cache when it holds the address. Unless
+// the cache keeps the store, a write transaction is issued, which must be
+// acknowledged before the next instruction is decoded
+func (cpu *cpu) write(position int, payload []parser.Msg) error {
+	address := cpu.address(position)
+
+	if cpu.bus.Device(address) == nil {
+		return raise(interrupt.FAULT)
+	}
+
+	value := cpu.decoder.Value(parser.Sorted(payload))
+	cpu.accessing(Access{Address: address, Write: true, Value: value})
+
+	if cpu.cacheable(cpu.caches.Data, address) {
+		cpu.claim(address)
+
+		if cpu.update(cpu.caches.Data, address, value) {
+			cpu.mark(address, cache.MODIFIED)
+			return nil
+		}
+	}
+
+	cpu.store(address, payload)
+
+	return nil
+}
+
+// store issues a write transaction to a bus address
+func (cpu *cpu) store(address int, payload []parser.Msg) {
+	key := cpu.nextKey()
+	cpu.stores[key] = address
+	cpu.bus.Route(cpu.name, b.WRITE, address, cpu.encoder.Transaction(key, address, payload))
+}
+
+// receive handles the replies to the data transactions, the replies to the
+// transactions abandoned by an exception are discarded
+func (cpu *cpu) receive(message []parser.Msg) {
+	key := message[0].Key
+
+	if position, ok := cpu.pending[key]; ok {
+		delete(cpu.pending, key)
+		cpu.loads[position] = cpu.decoder.Value(parser.Sorted(message))
+	}
+
+	if word, ok := cpu.fills[key]; ok {
+		delete(cpu.fills, key)
+		cpu.refilled(word, cpu.decoder.Value(parser.Sorted(message)))
+	}
+
+	delete(cpu.stores, key)
+}
+
+// abandon drops the instruction being executed and its loads, the lines
+// being refilled are still stored in the cache
+func (cpu *cpu) abandon() {
+	cpu.current = nil
+	cpu.announced = -1
+	cpu.loads = make(map[int]int)
+	cpu.pending = make(map[int]int)
+	cpu.misses = make(map[int]int)
+	cpu.guess = nil
+
+	if cpu.pipeline != nil {
+		cpu.squash(EX)
+		cpu.redirect()
+	}
+}
+
+// resolveParameter replaces a memory operand by the value loaded for it,
+// an operand which was not loaded raises a memory fault
+func (cpu *cpu) resolveParameter(parameter parser.Parameter) (parser.Parameter, error) {
+	value, ok := cpu.loads[parameter.Value]
+
+	if !ok {
+		return parameter, raise(interrupt.FAULT)
+	}
+
+	return parser.Parameter{
+		Value: value,
+		Type:  parser.LITERAL,
+	}, nil
+}
@@ -0,0 +1,111 @@
+package cpu
+
+import (
+	"strconv"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// isAtomic tells if an action reads, modifies and writes a word of the
+// memory while holding the bus
+func isAtomic(action int) bool {
+	return action == parser.Xchg || action == parser.Cmpxchg || action == parser.Xadd
+}
+
+// acquire reserves the bus before an atomic instruction starts, telling if
+// it can start. Its word is read from the memory, so the copies of its
+// line are written back and invalidated, including the ones of this core
+func (cpu *cpu) acquire(instruction parser.Action) bool {
+	if !isAtomic(instruction.Action) {
+		return true
+	}
+
+	if !cpu.bus.Lock(cpu.name) {
+		return false
+	}
+
+	cpu.locked = true
+
+	if instruction.Location.Type != parser.MEMORY {
+		return true
+	}
+
+	address := cpu.address(instruction.Location.Value)
+	if !cpu.cacheable(cpu.caches.Data, address) {
+		return true
+	}
+
+	base, size := cpu.span(address)
+
+	if cpu.coherent() {
+		cpu.bus.Broadcast(cpu.name, b.BUSRDX, base, size)
+	}
+
+	cpu.Snoop(b.BUSRDX, base, size)
+
+	return true
+}
+
+// release unlocks the bus once the atomic instruction completed and its
+// store was acknowledged
+func (cpu *cpu) release() {
+	if cpu.locked && cpu.current == nil && !cpu.isWaiting() {
+		cpu.bus.Unlock(cpu.name)
+		cpu.locked = false
+	}
+}
+
+// atomic executes an atomic instruction, the register given first receives
+// the word read from the memory:
+//
+//	xchg 0x010, A       stores A
+//	xadd 0x010, A       stores the word plus A
+//	cmpxchg 0x010, A, B stores B when the word equals A
+func (cpu *cpu) atomic(instruction parser.Action) error {
+	params := instruction.Parameters
+
+	length := 1
+	if instruction.Action == parser.Cmpxchg {
+		length = 2
+	}
+
+	return cpu.checkLength(params, length, func() error {
+		if params[0].Type != parser.REGISTER {
+			return raise(interrupt.ILLEGAL)
+		}
+
+		word, err := cpu.extractValue(instruction.Location)
+		if err != nil {
+			return err
+		}
+
+		value, err := cpu.get(params[0])
+		if err != nil {
+			return err
+		}
+
+		store := true
+
+		switch instruction.Action {
+		case parser.Xadd:
+			value += word
+		case parser.Cmpxchg:
+			store = word == value
+			if value, err = cpu.extractValue(params[1]); err != nil {
+				return err
+			}
+		}
+
+		if store {
+			message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
+
+			cpu.logStore(parser.Mnemonic(instruction.Action), instruction.Location.Value, value)
+			cpu.accessing(Access{Address: cpu.address(instruction.Location.Value), Write: true, Value: value})
+			cpu.store(cpu.address(instruction.Location.Value), message)
+		}
+
+		return cpu.logRegisters(cpu.set(params[0], word))
+	})
+}
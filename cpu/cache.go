@@ -0,0 +1,285 @@
+package cpu
+
+import (
+	"strconv"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+)
+
+// refill holds the words of a line being read from the memory into the
+// last level of the caches, and the accesses waiting for it
+type refill struct {
+	base      int
+	words     []int
+	remaining int
+	bottom    cache.Instance
+	waiters   []waiter
+	// shared tells if other cores hold the line, stale if it was written
+	// by another core while in flight and must not be kept
+	shared bool
+	stale  bool
+}
+
+// waiter is an access missing every level, starting at the first one
+type waiter struct {
+	first   cache.Instance
+	address int
+}
+
+// line identifies a line being refilled
+type line struct {
+	level cache.Instance
+	base  int
+}
+
+// fill is a word of a line being refilled
+type fill struct {
+	refill  *refill
+	address int
+}
+
+// levels returns the caches crossed by an access starting at the given
+// first level, ending with the unified one
+func (cpu *cpu) levels(first cache.Instance) []cache.Instance {
+	levels := make([]cache.Instance, 0)
+
+	if first != nil {
+		levels = append(levels, first)
+	}
+
+	if cpu.caches.Unified != nil && first != cpu.caches.Unified {
+		levels = append(levels, cpu.caches.Unified)
+	}
+
+	return levels
+}
+
+// cacheable tells if an address goes through the caches. The memory-mapped
+// I/O region and the lines not fully backed by the memory are not cached
+func (cpu *cpu) cacheable(first cache.Instance, address int) bool {
+	levels := cpu.levels(first)
+
+	if len(levels) == 0 || address >= b.IOBASE {
+		return false
+	}
+
+	bottom := levels[len(levels)-1]
+	base := bottom.Base(address)
+
+	return cpu.bus.Device(base) != nil && cpu.bus.Device(base+bottom.LineSize()-1) != nil
+}
+
+// read looks an address up level by level, copying the line into the
+// levels above the one holding it. When every level misses the line is
+// refilled from the memory
+func (cpu *cpu) read(first cache.Instance, address int) (int, bool) {
+	levels := cpu.levels(first)
+
+	for index, level := range levels {
+		cpu.busy += level.Latency()
+
+		if value, ok := level.Read(address); ok {
+			for _, upper := range levels[:index] {
+				cpu.fill(upper, level, address)
+			}
+
+			return value, true
+		}
+	}
+
+	cpu.refill(levels[len(levels)-1], waiter{first: first, address: address})
+
+	return 0, false
+}
+
+// peek returns the word at an address from the first level
+func (cpu *cpu) peek(first cache.Instance, address int) (int, bool) {
+	return cpu.levels(first)[0].Peek(address)
+}
+
+// lookup reads an operand through the data caches, waiting for its line
+// on a miss
+func (cpu *cpu) lookup(position int, address int) {
+	if value, ok := cpu.read(cpu.caches.Data, address); ok {
+		cpu.loads[position] = value
+		return
+	}
+
+	cpu.misses[position] = address
+}
+
+// fetchInstruction reads the instruction at an address through the
+// instruction caches, telling if it can be decoded. The instructions were
+// already fetched when the program was loaded, only their timing is
+// modeled
+func (cpu *cpu) fetchInstruction(address int) bool {
+	if !cpu.cacheable(cpu.caches.Instruction, address) || cpu.fetched == address {
+		cpu.fetched = -1
+		return true
+	}
+
+	if cpu.fetching == address {
+		return false
+	}
+
+	if _, ok := cpu.read(cpu.caches.Instruction, address); ok {
+		return true
+	}
+
+	cpu.fetching = address
+
+	return false
+}
+
+// refill issues a read transaction for every word of a line of the last
+// level, unless the line is already being read
+func (cpu *cpu) refill(bottom cache.Instance, access waiter) {
+	base := bottom.Base(access.address)
+
+	if pending, ok := cpu.refills[line{level: bottom, base: base}]; ok {
+		pending.waiters = append(pending.waiters, access)
+		return
+	}
+
+	size := bottom.LineSize()
+	pending := &refill{
+		base:      base,
+		words:     make([]int, size),
+		remaining: size,
+		bottom:    bottom,
+		waiters:   []waiter{access},
+	}
+
+	if bottom != cpu.caches.Instruction {
+		pending.shared, pending.stale = cpu.shared(base, size)
+	}
+
+	cpu.refills[line{level: bottom, base: base}] = pending
+	cpu.log.Debug("refill", "cycle", cpu.bus.Kernel().Cycle(), "base", base, "size", size, "shared", pending.shared)
+
+	for address := base; address < base+size; address++ {
+		key := cpu.nextKey()
+		cpu.fills[key] = fill{refill: pending, address: address}
+		cpu.bus.Route(cpu.name, b.READ, address, cpu.encoder.Transaction(key, address, nil))
+	}
+}
+
+// refilled stores a word of a line being refilled. Once every word arrives
+// the line is filled, along with the lines of the upper levels missed by
+// the waiting accesses, and the operands and instruction waiting for them
+// are loaded
+func (cpu *cpu) refilled(word fill, value int) {
+	pending := word.refill
+
+	pending.words[word.address-pending.base] = value
+	pending.remaining--
+
+	if pending.remaining > 0 {
+		return
+	}
+
+	delete(cpu.refills, line{level: pending.bottom, base: pending.base})
+
+	if victim, dirty := pending.bottom.Fill(pending.base, pending.words); dirty {
+		cpu.writeback(pending.bottom, victim)
+	}
+
+	if !pending.shared && cpu.caches.Coherence == cache.MESI {
+		pending.bottom.Mark(pending.base, cache.EXCLUSIVE)
+	}
+
+	for _, access := range pending.waiters {
+		levels := cpu.levels(access.first)
+
+		for _, upper := range levels[:len(levels)-1] {
+			cpu.fill(upper, pending.bottom, access.address)
+		}
+	}
+
+	for position, missed := range cpu.misses {
+		if value, ok := cpu.peek(cpu.caches.Data, missed); ok {
+			cpu.loads[position] = value
+			delete(cpu.misses, position)
+		}
+	}
+
+	if cpu.fetching >= 0 {
+		if _, ok := cpu.peek(cpu.caches.Instruction, cpu.fetching); ok {
+			cpu.fetched = cpu.fetching
+			cpu.fetching = -1
+		}
+	}
+
+	if pending.stale {
+		cpu.discard(pending.base, len(pending.words))
+	}
+}
+
+// fill copies the line holding an address from a lower level into an
+// upper one along with its coherence state, writing back the line it
+// evicts
+func (cpu *cpu) fill(upper cache.Instance, lower cache.Instance, address int) {
+	if upper.State(address) != cache.INVALID {
+		return
+	}
+
+	base := upper.Base(address)
+	words := make([]int, upper.LineSize())
+
+	for offset := range words {
+		words[offset], _ = lower.Peek(base + offset)
+	}
+
+	if victim, dirty := upper.Fill(base, words); dirty {
+		cpu.writeback(upper, victim)
+	}
+
+	upper.Mark(base, lower.State(address))
+}
+
+// update stores a word level by level, telling if a level keeps the store
+// and it must not reach the memory yet. The stores missing a level are
+// written around it
+func (cpu *cpu) update(first cache.Instance, address int, value int) bool {
+	for _, level := range cpu.levels(first) {
+		cpu.busy += level.Latency()
+
+		if level.Write(address, value) && level.Policy() == cache.WRITEBACK {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flush writes back every dirty line, starting with the first levels
+func (cpu *cpu) flush() {
+	for _, level := range []cache.Instance{cpu.caches.Data, cpu.caches.Unified} {
+		if level == nil {
+			continue
+		}
+
+		for _, victim := range level.Flush() {
+			cpu.writeback(level, victim)
+		}
+	}
+}
+
+// writeback stores every dirty word of a line leaving a level into the
+// levels below it, or into the memory
+func (cpu *cpu) writeback(level cache.Instance, victim cache.Victim) {
+	for offset, dirty := range victim.Dirty {
+		if !dirty {
+			continue
+		}
+
+		address := victim.Base + offset
+		value := victim.Words[offset]
+		cpu.log.Debug("write back", "cycle", cpu.bus.Kernel().Cycle(), "address", address, "value", value)
+
+		if level == cpu.caches.Unified || !cpu.update(cpu.caches.Unified, address, value) {
+			cpu.store(address, cpu.encoder.MapParams([]string{strconv.Itoa(value)}))
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package cpu
+
+import (
+	"strconv"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+)
+
+// coherent tells if the data caches are kept coherent with the other cores
+func (cpu *cpu) coherent() bool {
+	return cpu.caches.Coherence != cache.NONE && len(cpu.levels(cpu.caches.Data)) > 0
+}
+
+// span returns the first address and the size of the line of the last
+// data level holding an address, the unit of the coherence transactions
+func (cpu *cpu) span(address int) (int, int) {
+	levels := cpu.levels(cpu.caches.Data)
+	bottom := levels[len(levels)-1]
+
+	return bottom.Base(address), bottom.LineSize()
+}
+
+// state returns the coherence state of an address in the data levels, the
+// levels holding a line agree on its state
+func (cpu *cpu) state(address int) int {
+	for _, level := range cpu.levels(cpu.caches.Data) {
+		if state := level.State(address); state != cache.INVALID {
+			return state
+		}
+	}
+
+	return cache.INVALID
+}
+
+// mark changes the coherence state of the lines holding an address
+func (cpu *cpu) mark(address int, state int) {
+	for _, level := range cpu.levels(cpu.caches.Data) {
+		level.Mark(address, state)
+	}
+}
+
+// exclusive returns the state of a line only held by this core, MSI does
+// not tell the clean lines apart
+func (cpu *cpu) exclusive() int {
+	if cpu.caches.Coherence == cache.MESI {
+		return cache.EXCLUSIVE
+	}
+
+	return cache.MODIFIED
+}
+
+// shared broadcasts the read of a line missing every level, telling if the
+// other cores hold it and if it must not be kept
+func (cpu *cpu) shared(base int, size int) (bool, bool) {
+	if !cpu.coherent() {
+		return false, false
+	}
+
+	answer := cpu.bus.Broadcast(cpu.name, b.BUSRD, base, size)
+
+	return answer.Shared, answer.Pending
+}
+
+// claim invalidates the copies of the other cores before storing to an
+// address, unless this core already holds its line exclusively
+func (cpu *cpu) claim(address int) {
+	if !cpu.coherent() {
+		return
+	}
+
+	state := cpu.state(address)
+	if state == cache.EXCLUSIVE || state == cache.MODIFIED {
+		return
+	}
+
+	signal := b.BUSRDX
+	if state == cache.SHARED {
+		signal = b.BUSUPGR
+	}
+
+	base, size := cpu.span(address)
+	cpu.bus.Broadcast(cpu.name, signal, base, size)
+	cpu.mark(address, cpu.exclusive())
+}
+
+// Snoop applies the coherence transaction of another core to the lines
+// holding the words of a range. The dirty words are written back straight
+// to the memory, starting with the last level so the words of the first
+// ones prevail
+func (cpu *cpu) Snoop(signal int, base int, size int) b.Snoop {
+	answer := b.Snoop{}
+
+	for _, address := range cpu.stores {
+		if address >= base && address < base+size {
+			answer.Pending = true
+		}
+	}
+
+	levels := cpu.levels(cpu.caches.Data)
+
+	for index := len(levels) - 1; index >= 0; index-- {
+		level := levels[index]
+
+		for address := level.Base(base); address < base+size; address += level.LineSize() {
+			if level.State(address) == cache.INVALID {
+				continue
+			}
+
+			answer.Shared = true
+
+			if victim, dirty := level.Clean(address); dirty {
+				cpu.flushWords(victim)
+				answer.Flushed = true
+			}
+
+			if signal == b.BUSRD {
+				level.Mark(address, cache.SHARED)
+				continue
+			}
+
+			level.Invalidate(address)
+			answer.Invalidated = true
+		}
+	}
+
+	if signal != b.BUSRD {
+		for _, pending := range cpu.refills {
+			if pending.base < base+size && base < pending.base+len(pending.words) {
+				pending.stale = true
+			}
+		}
+	}
+
+	return answer
+}
+
+// flushWords writes the dirty words of a line straight to the memory
+func (cpu *cpu) flushWords(victim cache.Victim) {
+	for offset, dirty := range victim.Dirty {
+		if dirty {
+			cpu.bus.Write(victim.Base+offset, cpu.encoder.MapParams([]string{strconv.Itoa(victim.Words[offset])}))
+		}
+	}
+}
+
+// discard drops the lines of a refill invalidated while it was in flight,
+// once the accesses waiting for it were served
+func (cpu *cpu) discard(base int, size int) {
+	for _, level := range cpu.levels(cpu.caches.Data) {
+		for address := level.Base(base); address < base+size; address += level.LineSize() {
+			if victim, dirty := level.Invalidate(address); dirty {
+				cpu.writeback(level, victim)
+			}
+		}
+	}
+}
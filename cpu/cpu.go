@@ -1,260 +1,440 @@
 package cpu
 
 import (
-	"fmt"
 	"strconv"
-	"time"
-
-	"github.com/bruunoromero/cpu-emulator/memory"
 
 	"github.com/bradfitz/slice"
 	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/logger"
 	"github.com/bruunoromero/cpu-emulator/parser"
-	"github.com/bruunoromero/cpu-emulator/utils"
+	"github.com/bruunoromero/cpu-emulator/predictor"
+	"github.com/bruunoromero/cpu-emulator/timeline"
+	"github.com/bruunoromero/cpu-emulator/timing"
 )
 
-type cacheable struct {
-	value  int
-	access int
-}
+// This constants represents the bits of the flags register
+const (
+	// FlagCondition holds the result of the last conditional
+	FlagCondition = 1 << iota
+	// FlagInterrupt enables the servicing of interrupts
+	FlagInterrupt
+)
 
 type cpu struct {
+	id                      int
+	name                    string
+	entry                   int
 	pi                      int
+	pc                      int
+	flags                   int
 	memoryOffest            int
-	executionIndex          int
 	wordLenth               int
-	frequency               int
-	lastConditionalIndex    int
-	loopExecuting           int
-	isLooping               bool
-	isBuildingLoop          bool
+	busy                    int
+	fetching                int
+	fetched                 int
+	retired                 int
+	isHalted                bool
 	isWaitingForConditional bool
 	registers               []int
-	loops                   map[int]loop
+	stack                   []frame
+	key                     int
+	labels                  map[int]int
+	loads                   map[int]int
+	pending                 map[int]int
+	stores                  map[int]int
+	locked                  bool
+	timeline                timeline.Instance
+	log                     logger.Logger
+	hook                    Hook
+	announced               int
+	fetchStart              int
+	decodeCycle             int
+	decodePC                int
+	misses                  map[int]int
+	fills                   map[int]fill
+	refills                 map[line]*refill
+	current                 *parser.Action
+	guess                   *prediction
 	messageQueue            []parser.Msg
+	bus                     b.Instance
 	encoder                 parser.Encoder
 	decoder                 parser.Decoder
-	memory                  memory.Instance
-	cache                   map[int]cacheable
+	interrupts              interrupt.Instance
+	costs                   timing.Costs
+	caches                  cache.Levels
+	pipeline                *pipeline
+	predictor               predictor.Instance
 	executionMap            map[int][]parser.Msg
 }
 
 // Instance is the interface of the cpu type
 type Instance interface {
 	Run(b.Instance)
-	get(parser.Parameter) int
-	set(parser.Parameter, int)
-	executeOrAbort(int, func(*int) int) int
-}
-
-type loop struct {
-	condition    parser.Action
-	ifTrue       parser.Action
-	ifFalse      parser.Action
-	instructions []parser.Action
+	Name() string
+	Idle() bool
+	Halted() bool
+	Retired() int
+	Pipeline() (PipelineStats, bool)
+	PC() int
+	Flags() int
+	Registers() []int
+	SetRegister(int, int) bool
+	State() State
+	Restore(State)
+	Snapshot() Snapshot
+	LoadSnapshot(Snapshot)
+	Label(int) (int, bool)
+	get(parser.Parameter) (int, error)
+	set(parser.Parameter, int) error
+	executeOrRaise(int, func(*int) int) (int, error)
+}
+
+// IDREGISTER is the name of the register holding the identifier of the
+// core, it is the last register of every core and it is read-only
+const IDREGISTER = "ID"
+
+// Name returns the name of the bus channel of the core with the given
+// identifier
+func Name(id int) string {
+	return "cpu" + strconv.Itoa(id)
+}
+
+// Config describes a core
+type Config struct {
+	// ID identifies the core, only the core 0 services the hardware lines
+	ID int
+	// Entry is the label where the core starts, 0 starts it at the
+	// beginning of the program
+	Entry int
+	// Registers is the number of registers, including the ID register
+	Registers int
+	Word      int
+	Memory    int
+	// Costs are spent on every instruction
+	Costs timing.Costs
+	// Caches are crossed by the instruction fetches and the data
+	// accesses, which go straight to the bus when they are disabled
+	Caches    cache.Levels
+	Pipeline  Pipeline
+	Predictor predictor.Instance
+	// Timeline records the fetch, decode and execution of the
+	// instructions when it is not nil
+	Timeline timeline.Instance
+	// Hook is notified before every instruction starts when it is not
+	// nil
+	Hook Hook
 }
 
 // New returns a new instance of CPU
-func New(registers int, word int, memory int, frequency int, memoryI memory.I, encoder parser.Encoder) Instance {
+func New(config Config, interrupts interrupt.Instance, encoder parser.Encoder) Instance {
+	registers := make([]int, config.Registers)
+	registers[len(registers)-1] = config.ID
+
 	return &cpu{
-		lastConditionalIndex:    0,
-		executionIndex:          0,
-		loopExecuting:           -1,
+		id:                      config.ID,
+		name:                    Name(config.ID),
+		entry:                   config.Entry,
 		pi:                      -1,
-		isBuildingLoop:          false,
-		isLooping:               false,
-		wordLenth:               word,
+		pc:                      0,
+		flags:                   0,
+		isHalted:                false,
+		wordLenth:               config.Word,
 		isWaitingForConditional: false,
-		memory:                  memoryI,
 		encoder:                 encoder,
-		frequency:               frequency,
-		memoryOffest:            (memory / 2) - 1,
-		loops:                   make(map[int]loop),
+		costs:                   config.Costs,
+		interrupts:              interrupts,
+		memoryOffest:            (config.Memory / 2) - 1,
+		stack:                   make([]frame, 0),
+		key:                     DATAKEYS,
+		labels:                  make(map[int]int),
+		loads:                   make(map[int]int),
+		pending:                 make(map[int]int),
+		stores:                  make(map[int]int),
+		misses:                  make(map[int]int),
+		fetching:                -1,
+		fetched:                 -1,
+		fills:                   make(map[int]fill),
+		refills:                 make(map[line]*refill),
 		messageQueue:            make([]parser.Msg, 0),
-		registers:               make([]int, registers),
-		cache:                   make(map[int]cacheable),
-		decoder:                 parser.NewDecoder(word),
+		registers:               registers,
+		caches:                  config.Caches,
+		pipeline:                newPipeline(config.Pipeline),
+		predictor:               config.Predictor,
+		timeline:                config.Timeline,
+		log:                     logger.For(logger.CPU).With("core", Name(config.ID)),
+		hook:                    config.Hook,
+		announced:               -1,
+		fetchStart:              -1,
+		decoder:                 parser.NewDecoder(config.Word),
 		executionMap:            make(map[int][]parser.Msg),
 	}
 }
 
-func mapSlice(vs []parser.Msg, f func(parser.Msg) byte) []byte {
-	vsm := make([]byte, len(vs))
-	for i, v := range vs {
-		vsm[i] = f(v)
+func (cpu *cpu) Run(bus b.Instance) {
+	cpu.bus = bus
+
+	if cpu.coherent() {
+		bus.Observe(cpu)
 	}
-	return vsm
+
+	bus.Kernel().Every(1, func() {
+		cpu.cycle(bus)
+	})
 }
 
-func getValue(msg parser.Msg) byte {
-	return msg.Value
+// Name returns the name of the bus channel of the core
+func (cpu *cpu) Name() string {
+	return cpu.name
 }
 
-func (cpu *cpu) Run(bus b.Instance) {
-	go func() {
-		for {
-			data := bus.ReceiveFrom("cpuData")
-			address := bus.ReceiveFrom("cpuAddress")
-			instructions := bus.ReceiveFrom("cpuInstruction")
-
-			messages := cpu.decoder.GetMessagesWithQueue(address.Payload, data.Payload, instructions.Payload, &cpu.messageQueue)
-
-			slice.Sort(messages, func(i int, j int) bool {
-				return messages[i][0].Key < messages[j][0].Key
-			})
-
-			for _, message := range messages {
-				if len(message) > 0 {
-					msg := message[0]
-					if msg.Signal == b.READ {
-						if msg.Key != cpu.pi+1 {
-							cpu.messageQueue = append(message, cpu.messageQueue...)
-							continue
-						}
-						cpu.pi++
-						bus.SendTo("memory", "cpu", b.READ, []parser.Msg{parser.Msg{Key: int(msg.Value), Index: 0, Lenght: 0, Type: parser.MEMORY, Value: msg.Value}})
-					} else {
-
-						cpu.executionMap[msg.Key] = message
-						if cpu.executionMap[cpu.executionIndex] == nil {
-							continue
-						}
-
-						instruction := cpu.decoder.Decode(cpu.executionMap[cpu.executionIndex])
-						cpu.executeInstruction(instruction)
-						cpu.executionIndex++
-					}
+// Halted tells if the core executed hlt
+func (cpu *cpu) Halted() bool {
+	return cpu.isHalted
+}
+
+// Idle tells if the cpu halted or ran out of instructions with the
+// interrupts disabled, having nothing left to wait for
+func (cpu *cpu) Idle() bool {
+	if cpu.isHalted {
+		return true
+	}
+
+	return cpu.flags&FlagInterrupt == 0 &&
+		cpu.busy == 0 &&
+		cpu.current == nil &&
+		(cpu.pipeline == nil || cpu.pipeline.empty()) &&
+		!cpu.isWaiting() &&
+		len(cpu.stack) == 0 &&
+		len(cpu.messageQueue) == 0 &&
+		cpu.executionMap[cpu.pc] == nil
+}
+
+// Retired returns the number of instructions executed
+func (cpu *cpu) Retired() int {
+	return cpu.retired
+}
+
+func (cpu *cpu) cycle(bus b.Instance) {
+	data := bus.ReceiveFrom(cpu.name + b.DATA)
+	address := bus.ReceiveFrom(cpu.name + b.ADDRESS)
+	instructions := bus.ReceiveFrom(cpu.name + b.INSTUCTION)
+
+	messages := cpu.decoder.GetMessagesWithQueue(address.Payload, data.Payload, instructions.Payload, &cpu.messageQueue)
+
+	slice.Sort(messages, func(i int, j int) bool {
+		return messages[i][0].Key < messages[j][0].Key
+	})
+
+	for _, message := range messages {
+		if len(message) > 0 {
+			msg := message[0]
+			if msg.Signal == b.READ {
+				if msg.Key != cpu.pi+1 {
+					cpu.messageQueue = append(message, cpu.messageQueue...)
+					continue
 				}
+				cpu.pi++
+				bus.Route(cpu.name, b.READ, int(msg.Value), cpu.encoder.Transaction(int(msg.Value), int(msg.Value), nil))
+			} else if msg.Key >= DATAKEYS {
+				cpu.receive(message)
+			} else {
+				cpu.fetch(message)
 			}
-
-			time.Sleep(time.Second / (time.Duration(cpu.frequency) * 4))
 		}
-	}()
+	}
+
+	cpu.release()
+
+	if cpu.pipeline != nil {
+		cpu.advance()
+	} else {
+		cpu.step()
+	}
 }
 
-func (cpu *cpu) shouldExecute(instruction parser.Action) bool {
-	if isConditional(instruction.Action) {
-		loop := cpu.loops[cpu.loopExecuting]
-		loop.condition = instruction
-		cpu.isWaitingForConditional = true
-		cpu.lastConditionalIndex = instruction.Key
-		cpu.loops[cpu.loopExecuting] = loop
-		return false
-	} else if cpu.isWaitingForConditional {
-		if instruction.Key == cpu.lastConditionalIndex+1 {
-			loop := cpu.loops[cpu.loopExecuting]
-			loop.ifTrue = instruction
-			cpu.loops[cpu.loopExecuting] = loop
-			return false
-		} else if instruction.Key == cpu.lastConditionalIndex+2 {
-			loop := cpu.loops[cpu.loopExecuting]
-			cpu.isBuildingLoop = false
-			cpu.isWaitingForConditional = false
-			loop.ifFalse = instruction
-			cpu.loops[cpu.loopExecuting] = loop
-			cpu.branchLoop()
-			return false
-		}
+// fetch stores a instruction received from the memory, registering its
+// address when it declares a label
+func (cpu *cpu) fetch(message []parser.Msg) {
+	key := message[0].Key
+	cpu.executionMap[key] = message
+
+	instruction := cpu.decoder.Decode(message)
+	if instruction.Action == parser.Label {
+		cpu.labels[instruction.Location.Value] = key
 	}
+}
 
-	return true
+// step services a pending interrupt or decodes the instruction pointed by
+// the program counter, loading its operands from the bus. The instruction
+// is executed once the operands arrive and the next one is only decoded
+// after its stores are acknowledged and the cycles of the instruction
+// elapse
+func (cpu *cpu) step() {
+	if err := cpu.progress(); err != nil {
+		cpu.except(err)
+	}
 }
 
-func (cpu *cpu) cacheInstructions(instruction parser.Action) {
-	if cpu.isBuildingLoop {
-		cached := parser.Action{
-			Key:        instruction.Key,
-			Signal:     instruction.Signal,
-			Origin:     instruction.Origin,
-			Action:     instruction.Action,
-			Location:   instruction.Location,
-			Parameters: make([]parser.Parameter, 0),
+// progress moves the instruction pointed by the program counter forward,
+// returning the exception it raised
+func (cpu *cpu) progress() error {
+	if cpu.busy > 0 {
+		cpu.busy--
+		return nil
+	}
+
+	if cpu.isHalted || cpu.isWaiting() || !cpu.boot() {
+		return nil
+	}
+
+	if cpu.current != nil {
+		return cpu.execute()
+	}
+
+	if cpu.isInterruptible() {
+		if line, ok := cpu.interrupts.Pending(); ok && cpu.isLoaded(interrupt.IRQ+line) {
+			cpu.interrupt(line)
 		}
+	}
+
+	message := cpu.executionMap[cpu.pc]
+	if message == nil {
+		return nil
+	}
+
+	instruction := cpu.decoder.Decode(message)
 
-		for _, parameter := range instruction.Parameters {
-			cached.Parameters = append(cached.Parameters, parser.Parameter{
-				Type:  parameter.Type,
-				Value: parameter.Value,
-			})
+	// The program is still being loaded, wait for the label to be fetched
+	if instruction.Action == parser.Jump && instruction.Location.Type == parser.LITERAL {
+		if _, ok := cpu.labels[instruction.Location.Value]; !ok {
+			return nil
 		}
+	}
 
-		loop := cpu.loops[cpu.loopExecuting]
-		loop.instructions = append(loop.instructions, cached)
-		cpu.loops[cpu.loopExecuting] = loop
+	if !cpu.starting() {
+		return nil
 	}
-}
 
-func (cpu *cpu) syncCache() {
-	cpu.lfu()
-}
+	cpu.fetchStarted()
+
+	if !cpu.fetchInstruction(cpu.pc) {
+		return nil
+	}
 
-func (cpu *cpu) lfu() {
-	for position, cache := range cpu.cache {
-		if cache.access >= 5 {
-			cpu.writeToMemory(position, cache.value)
-			cache.access = 0
-			cpu.cache[position] = cache
+	skipped := cpu.isWaitingForConditional && cpu.flags&FlagCondition == 0
+	if !skipped && !cpu.acquire(instruction) {
+		return nil
+	}
+
+	pc := cpu.pc
+	cpu.pc++
+	cpu.decoded(instruction, pc)
+
+	if cpu.isWaitingForConditional {
+		cpu.isWaitingForConditional = false
+
+		if cpu.flags&FlagCondition == 0 {
+			return nil
 		}
+
+		cpu.pc++
 	}
+
+	if cpu.predictor != nil && cpu.isBranch(instruction) {
+		cpu.guess = &prediction{pc: pc, taken: cpu.predictor.Predict(pc)}
+	}
+
+	cpu.current = &instruction
+	if err := cpu.load(instruction); err != nil {
+		return err
+	}
+
+	if !cpu.isWaiting() {
+		return cpu.execute()
+	}
+
+	return nil
 }
 
-func (cpu *cpu) executeInstruction(instruction parser.Action) {
-	if cpu.shouldExecute(instruction) {
-		cpu.cacheInstructions(instruction)
+// execute runs the instruction decoded, returning the exception it raised.
+// An instruction raising an exception is not completed
+func (cpu *cpu) execute() error {
+	instruction := *cpu.current
 
-		for index, parameter := range instruction.Parameters {
-			if parameter.Type == parser.MEMORY {
-				instruction.Parameters[index] = cpu.resolveParameter(parameter)
-			}
-		}
+	cpu.current = nil
 
-		switch instruction.Location.Type {
-		case parser.MEMORY:
-			if cpu.isLooping {
-				cpu.executeOnCache(instruction)
-			} else {
-				cpu.executeOnMemory(instruction)
+	if err := cpu.executeInstruction(instruction); err != nil {
+		return err
+	}
+
+	cpu.retired++
+	cpu.busy += cpu.costs.Of(instruction.Action) - 1
+
+	cpu.loads = make(map[int]int)
+	cpu.resolve(instruction)
+	cpu.executed(instruction)
+	cpu.finished()
+
+	return nil
+}
+
+func (cpu *cpu) executeInstruction(instruction parser.Action) error {
+	if isConditional(instruction.Action) {
+		return cpu.branch(instruction)
+	}
+
+	for index, parameter := range instruction.Parameters {
+		if parameter.Type == parser.MEMORY {
+			resolved, err := cpu.resolveParameter(parameter)
+			if err != nil {
+				return err
 			}
-		default:
-			cpu.executeOnRegister(instruction)
+
+			instruction.Parameters[index] = resolved
 		}
 	}
+
+	switch instruction.Location.Type {
+	case parser.MEMORY:
+		return cpu.executeOnMemory(instruction)
+	default:
+		return cpu.executeOnRegister(instruction)
+	}
 }
 
-func (cpu *cpu) executeOnRegister(instruction parser.Action) {
+func (cpu *cpu) executeOnRegister(instruction parser.Action) error {
 	switch instruction.Action {
 	case parser.Inc:
-		cpu.add(instruction.Location, []parser.Parameter{parser.Parameter{Type: parser.LITERAL, Value: 1}})
-		fmt.Println("registers", cpu.registers)
+		return cpu.logRegisters(cpu.add(instruction.Location, []parser.Parameter{parser.Parameter{Type: parser.LITERAL, Value: 1}}))
 	case parser.Add:
-		cpu.add(instruction.Location, instruction.Parameters)
-		fmt.Println("registers", cpu.registers)
+		return cpu.logRegisters(cpu.add(instruction.Location, instruction.Parameters))
 	case parser.Mov:
-		cpu.mov(instruction.Location, instruction.Parameters)
-		fmt.Println("registers", cpu.registers)
+		return cpu.logRegisters(cpu.mov(instruction.Location, instruction.Parameters))
 	case parser.Imul:
-		cpu.imul(instruction.Location, instruction.Parameters)
-		fmt.Println("registers", cpu.registers)
+		return cpu.logRegisters(cpu.imul(instruction.Location, instruction.Parameters))
 	case parser.Label:
-		cpu.label(instruction.Location)
+		// The labels are registered when fetched
 	case parser.Jump:
-		cpu.jump(instruction.Location)
+		return cpu.jump(instruction.Location)
 	case parser.NULL:
 		cpu.null()
+	case parser.Ei:
+		cpu.flags |= FlagInterrupt
+	case parser.Di:
+		cpu.flags &^= FlagInterrupt
+	case parser.Iret:
+		return cpu.iret()
+	case parser.Idiv:
+		return cpu.logRegisters(cpu.idiv(instruction.Location, instruction.Parameters))
+	case parser.Halt:
+		cpu.halt()
+	default:
+		return raise(interrupt.ILLEGAL)
 	}
-}
 
-func (cpu *cpu) executeOnCache(instruction parser.Action) {
-	switch instruction.Action {
-	case parser.Inc:
-		cpu.incOnCache(instruction.Location)
-	case parser.Add:
-		cpu.addOnCache(instruction.Location, instruction.Parameters)
-	case parser.Mov:
-		cpu.movOnCache(instruction.Location, instruction.Parameters)
-	case parser.Imul:
-		cpu.imulOnCache(instruction.Location, instruction.Parameters)
-	}
+	return nil
 }
 
 func isConditional(action int) bool {
@@ -265,63 +445,71 @@ func isConditional(action int) bool {
 		action == parser.LTEQ
 }
 
-func (cpu *cpu) branchLoop() {
-	loop := cpu.loops[cpu.loopExecuting]
-	isTrue := cpu.executeCondition()
+// branch evaluates a conditional, the next instruction is executed only
+// when it holds and the one after it only when it does not
+func (cpu *cpu) branch(condition parser.Action) error {
+	holds, err := cpu.executeCondition(condition)
+	if err != nil {
+		return err
+	}
 
-	cpu.isLooping = false
+	cpu.isWaitingForConditional = true
 
-	if isTrue {
-		cpu.executeInstruction(loop.ifTrue)
+	if holds {
+		cpu.flags |= FlagCondition
 	} else {
-		cpu.executeInstruction(loop.ifFalse)
+		cpu.flags &^= FlagCondition
 	}
-}
 
-func (cpu *cpu) executeLoop() {
-	loop := cpu.loops[cpu.loopExecuting]
+	return nil
+}
 
-	for _, instruction := range loop.instructions {
-		cpu.syncCache()
-		cpu.executeInstruction(instruction)
+func (cpu *cpu) executeCondition(condition parser.Action) (bool, error) {
+	if len(condition.Parameters) != 1 {
+		return false, raise(interrupt.ILLEGAL)
 	}
 
-	cpu.branchLoop()
-}
-
-func (cpu *cpu) executeCondition() bool {
-	condition := cpu.loops[cpu.loopExecuting].condition
-	left := condition.Location
-	right := condition.Parameters[0]
+	leftValue, err := cpu.extractValue(condition.Location)
+	if err != nil {
+		return false, err
+	}
 
-	leftValue := cpu.extractValue(left)
-	rightValue := cpu.extractValue(right)
+	rightValue, err := cpu.extractValue(condition.Parameters[0])
+	if err != nil {
+		return false, err
+	}
 
 	if condition.Action == parser.EQ {
-		return leftValue == rightValue
+		return leftValue == rightValue, nil
 	} else if condition.Action == parser.GT {
-		return leftValue > rightValue
+		return leftValue > rightValue, nil
 	} else if condition.Action == parser.LT {
-		return leftValue < rightValue
+		return leftValue < rightValue, nil
 	} else if condition.Action == parser.GTEQ {
-		return leftValue >= rightValue
+		return leftValue >= rightValue, nil
 	} else if condition.Action == parser.LTEQ {
-		return leftValue <= rightValue
+		return leftValue <= rightValue, nil
 	}
 
-	return false
+	return false, nil
 }
 
-func (cpu *cpu) executeOnMemory(instruction parser.Action) {
+func (cpu *cpu) executeOnMemory(instruction parser.Action) error {
 	switch instruction.Action {
 	case parser.Inc:
-		cpu.incOnMemory(instruction.Location)
+		return cpu.incOnMemory(instruction.Location)
 	case parser.Add:
-		cpu.addOnMemory(instruction.Location, instruction.Parameters)
+		return cpu.addOnMemory(instruction.Location, instruction.Parameters)
 	case parser.Mov:
-		cpu.movOnMemory(instruction.Location, instruction.Parameters)
+		return cpu.movOnMemory(instruction.Location, instruction.Parameters)
 	case parser.Imul:
-		cpu.imulOnMemory(instruction.Location, instruction.Parameters)
+		return cpu.imulOnMemory(instruction.Location, instruction.Parameters)
+	case parser.Idiv:
+		return cpu.idivOnMemory(instruction.Location, instruction.Parameters)
+	case parser.Xchg, parser.Cmpxchg, parser.Xadd:
+		return cpu.atomic(instruction)
+	default:
+		return raise(interrupt.ILLEGAL)
 	}
 }
 
@@ -347,221 +535,264 @@ func findFirstUnresolved(params []parser.Parameter) *parser.Parameter {
 	return nil
 }
 
-func checkLengthOrAbort(params []parser.Parameter, length int, callback func()) {
-	if len(params) == length {
-		callback()
-	} else {
-		utils.Abort("Unexpected number of parameters for this action")
+func (cpu *cpu) checkLength(params []parser.Parameter, length int, callback func() error) error {
+	if len(params) != length {
+		return raise(interrupt.ILLEGAL)
 	}
+
+	return callback()
 }
 
-func (cpu *cpu) extractValue(value parser.Parameter) int {
+// extractValues returns the values of the parameters, or the exception
+// raised by the first one which can not be read
+func (cpu *cpu) extractValues(params ...parser.Parameter) ([]int, error) {
+	values := make([]int, len(params))
+
+	for index, param := range params {
+		value, err := cpu.extractValue(param)
+		if err != nil {
+			return nil, err
+		}
+
+		values[index] = value
+	}
+
+	return values, nil
+}
+
+func (cpu *cpu) extractValue(value parser.Parameter) (int, error) {
 	if value.Type == parser.REGISTER {
 		return cpu.get(value)
 	} else if value.Type == parser.LITERAL {
-		return value.Value
+		return value.Value, nil
 	} else if value.Type == parser.MEMORY {
-		if cpu.isLooping {
-			if cacheValue, ok := cpu.cache[value.Value]; ok {
-				cacheValue.access++
-				cpu.cache[value.Value] = cacheValue
-
-				return cacheValue.value
-			}
-
-			v := value.Value
+		resolved, err := cpu.resolveParameter(value)
+		return resolved.Value, err
+	}
 
-			cpu.cache[v] = cacheable{
-				access: 1,
-				value:  v,
-			}
+	return 0, raise(interrupt.ILLEGAL)
+}
 
-			return v
-		}
+func (cpu *cpu) jump(label parser.Parameter) error {
+	value, err := cpu.extractValue(label)
+	if err != nil {
+		return err
+	}
 
-		return cpu.resolveParameter(value).Value
+	address, ok := cpu.labels[value]
+	if !ok {
+		return raise(interrupt.FAULT)
 	}
 
-	utils.Abort("undefined type")
-	return 0
-}
+	cpu.pc = address
 
-func (cpu *cpu) jump(label parser.Parameter) {
-	cpu.isLooping = true
-	cpu.isBuildingLoop = false
-	cpu.loopExecuting = cpu.extractValue(label)
-	cpu.executeLoop()
+	return nil
 }
 
+// null ends a loop, writing back the stores kept by the cache
 func (cpu *cpu) null() {
-	cpu.isLooping = false
-	cpu.loopExecuting = -1
-	cpu.syncCache()
+	cpu.flush()
+}
+
+// halt stops the core, the machine stops once every core halted
+func (cpu *cpu) halt() {
+	cpu.isHalted = true
+	cpu.log.Info("halted", "cycle", cpu.bus.Kernel().Cycle(), "retired", cpu.retired)
 }
 
-func (cpu *cpu) label(label parser.Parameter) {
-	loopIndex := cpu.extractValue(label)
-	cpu.isLooping = true
-	cpu.isBuildingLoop = true
-	cpu.loopExecuting = loopIndex
-	cpu.loops[loopIndex] = loop{
-		instructions: make([]parser.Action, 0),
+// logRegisters writes the registers after an instruction writing them,
+// unless it raised an exception which is returned
+func (cpu *cpu) logRegisters(err error) error {
+	if err == nil {
+		cpu.log.Info("registers", "cycle", cpu.bus.Kernel().Cycle(), "values", cpu.registers)
 	}
+
+	return err
 }
 
-func (cpu *cpu) mov(register parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 1, func() {
-		value := params[0]
-		cpu.set(register, cpu.extractValue(value))
-	})
+// logStore writes the word stored by an instruction into a data position
+func (cpu *cpu) logStore(action string, position int, value int) {
+	cpu.log.Info(action+" on memory", "cycle", cpu.bus.Kernel().Cycle(), "position", cpu.address(position), "value", value)
 }
 
-func (cpu *cpu) add(register parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 1, func() {
-		v := cpu.get(register)
-		value := params[0]
-		cpu.set(register, v+cpu.extractValue(value))
-	})
+// boot points the program counter to the entry point of the core once its
+// label is fetched, telling if the core started
+func (cpu *cpu) boot() bool {
+	if cpu.entry == 0 {
+		return true
+	}
+
+	address, ok := cpu.labels[cpu.entry]
+	if !ok {
+		return false
+	}
+
+	cpu.pc = address
+	cpu.entry = 0
+
+	if cpu.pipeline != nil {
+		cpu.pipeline.next = address
+	}
+
+	return true
 }
 
-func (cpu *cpu) imul(register parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 2, func() {
-		value1 := params[0]
-		value2 := params[1]
-		cpu.set(register, cpu.extractValue(value1)*cpu.extractValue(value2))
+func (cpu *cpu) mov(register parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 1, func() error {
+		value, err := cpu.extractValue(params[0])
+		if err != nil {
+			return err
+		}
+
+		return cpu.set(register, value)
 	})
 }
 
-func (cpu *cpu) movOnMemory(memory parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 1, func() {
-		value := cpu.extractValue(params[0])
-		message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
+func (cpu *cpu) add(register parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 1, func() error {
+		v, err := cpu.get(register)
+		if err != nil {
+			return err
+		}
+
+		value, err := cpu.extractValue(params[0])
+		if err != nil {
+			return err
+		}
 
-		fmt.Println("mov on memory position: ", memory.Value+cpu.memoryOffest, "; value: ", message)
-		cpu.memory.Write(byte(memory.Value+cpu.memoryOffest), message)
+		return cpu.set(register, v+value)
 	})
 }
 
-func (cpu *cpu) addOnMemory(memory parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 1, func() {
-		memoryValue := cpu.resolveParameter(memory)
-		v := cpu.extractValue(params[0])
-		value := v + memoryValue.Value
-		message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
+func (cpu *cpu) imul(register parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 2, func() error {
+		values, err := cpu.extractValues(params...)
+		if err != nil {
+			return err
+		}
 
-		fmt.Println("add on memory position: ", memory.Value+cpu.memoryOffest, "; value: ", message)
-		cpu.memory.Write(byte(memory.Value+cpu.memoryOffest), message)
+		return cpu.set(register, values[0]*values[1])
 	})
 }
 
-func (cpu *cpu) imulOnMemory(memory parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 2, func() {
-		v0 := cpu.extractValue(params[0])
-		v1 := cpu.extractValue(params[1])
-		value := v0 * v1
-		message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
+func (cpu *cpu) idiv(register parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 2, func() error {
+		values, err := cpu.extractValues(params...)
+		if err != nil {
+			return err
+		}
+
+		value, err := divide(values[0], values[1])
+		if err != nil {
+			return err
+		}
 
-		fmt.Println("imul on memory position: ", memory.Value+cpu.memoryOffest, "; value: ", message)
-		cpu.memory.Write(byte(memory.Value+cpu.memoryOffest), message)
+		return cpu.set(register, value)
 	})
 }
 
-func (cpu *cpu) incOnMemory(memory parser.Parameter) {
-	memoryValue := cpu.resolveParameter(memory)
-	value := memoryValue.Value + 1
-	message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
-	fmt.Println("inc on memory position: ", memory.Value+cpu.memoryOffest, "; value: ", message)
-	cpu.memory.Write(byte(memory.Value+cpu.memoryOffest), message)
+func divide(dividend int, divisor int) (int, error) {
+	if divisor == 0 {
+		return 0, raise(interrupt.DIVIDE)
+	}
+
+	return dividend / divisor, nil
 }
 
-func (cpu *cpu) movOnCache(cache parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 1, func() {
-		cpu.extractValue(cache)
+// storeOnMemory writes the value of an instruction to a data position
+func (cpu *cpu) storeOnMemory(action string, position int, value int) error {
+	message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
+
+	cpu.logStore(action, position, value)
+	return cpu.write(position, message)
+}
 
-		value := cpu.extractValue(params[0])
+func (cpu *cpu) movOnMemory(memory parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 1, func() error {
+		value, err := cpu.extractValue(params[0])
+		if err != nil {
+			return err
+		}
 
-		cacheEl := cpu.cache[cache.Value]
-		cacheEl.value = value
-		cpu.cache[cache.Value] = cacheEl
-		fmt.Println("mov on cache position: ", cache.Value, "; value: ", cacheEl.value)
+		return cpu.storeOnMemory("mov", memory.Value, value)
 	})
 }
 
-func (cpu *cpu) addOnCache(cache parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 1, func() {
-		value := cpu.extractValue(cache) + cpu.extractValue(params[0])
+func (cpu *cpu) addOnMemory(memory parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 1, func() error {
+		memoryValue, err := cpu.extractValue(memory)
+		if err != nil {
+			return err
+		}
 
-		cacheEl := cpu.cache[cache.Value]
-		cacheEl.value = value
-		cpu.cache[cache.Value] = cacheEl
+		v, err := cpu.extractValue(params[0])
+		if err != nil {
+			return err
+		}
 
-		fmt.Println("add on cache position: ", cache.Value, "; value: ", cacheEl.value)
+		return cpu.storeOnMemory("add", memory.Value, v+memoryValue)
 	})
 }
 
-func (cpu *cpu) imulOnCache(cache parser.Parameter, params []parser.Parameter) {
-	checkLengthOrAbort(params, 2, func() {
-		cpu.extractValue(cache)
-
-		fmt.Println(params[0].Type == parser.LITERAL)
-		v0 := cpu.extractValue(params[0])
-		v1 := cpu.extractValue(params[1])
-		value := v0 * v1
+func (cpu *cpu) imulOnMemory(memory parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 2, func() error {
+		values, err := cpu.extractValues(params...)
+		if err != nil {
+			return err
+		}
 
-		cacheEl := cpu.cache[cache.Value]
-		cacheEl.value = value
-		cpu.cache[cache.Value] = cacheEl
-		// fmt.Println("imul on cache position: ", cache.Value, "; value: ", cacheEl.value)
+		return cpu.storeOnMemory("imul", memory.Value, values[0]*values[1])
 	})
 }
 
-func (cpu *cpu) incOnCache(cache parser.Parameter) {
-	value := cpu.extractValue(cache) + 1
+func (cpu *cpu) idivOnMemory(memory parser.Parameter, params []parser.Parameter) error {
+	return cpu.checkLength(params, 2, func() error {
+		values, err := cpu.extractValues(params...)
+		if err != nil {
+			return err
+		}
 
-	cacheEl := cpu.cache[cache.Value]
-	cacheEl.value = value
-	cpu.cache[cache.Value] = cacheEl
+		value, err := divide(values[0], values[1])
+		if err != nil {
+			return err
+		}
 
-	fmt.Println("inc on cache position: ", cache.Value, "; value: ", cacheEl.value)
+		return cpu.storeOnMemory("idiv", memory.Value, value)
+	})
 }
 
-func (cpu *cpu) writeToMemory(position int, value int) {
-	message := cpu.encoder.MapParams([]string{strconv.Itoa(value)})
-	cpu.memory.Write(byte(position+cpu.memoryOffest), message)
+func (cpu *cpu) incOnMemory(memory parser.Parameter) error {
+	memoryValue, err := cpu.extractValue(memory)
+	if err != nil {
+		return err
+	}
 
-	fmt.Println("write on memory position: ", position+cpu.memoryOffest, "; value: ", message)
+	return cpu.storeOnMemory("inc", memory.Value, memoryValue+1)
 }
 
-func (cpu *cpu) set(location parser.Parameter, value int) {
-	cpu.executeOrAbort(location.Value, func(register *int) int {
+func (cpu *cpu) set(location parser.Parameter, value int) error {
+	if location.Value == len(cpu.registers)-1 {
+		return raise(interrupt.ILLEGAL)
+	}
+
+	_, err := cpu.executeOrRaise(location.Value, func(register *int) int {
 		*register = value
 		return *register
 	})
+
+	return err
 }
 
-func (cpu *cpu) get(location parser.Parameter) int {
-	return cpu.executeOrAbort(location.Value, func(register *int) int {
+func (cpu *cpu) get(location parser.Parameter) (int, error) {
+	return cpu.executeOrRaise(location.Value, func(register *int) int {
 		return *register
 	})
 }
 
-func (cpu *cpu) executeOrAbort(register int, callback func(*int) int) int {
+func (cpu *cpu) executeOrRaise(register int, callback func(*int) int) (int, error) {
 	if len(cpu.registers)-1 < register || register < 0 {
-		utils.Abort("Error while accessing register")
-	} else {
-		return callback(&cpu.registers[register])
+		return 0, raise(interrupt.ILLEGAL)
 	}
 
-	return -1
-}
-
-func (cpu *cpu) resolveParameter(parameter parser.Parameter) parser.Parameter {
-	msg := cpu.memory.Read(byte(parameter.Value + cpu.memoryOffest))
-	bytes := mapSlice(msg, getValue)
-	value := utils.FromBytes(cpu.wordLenth, bytes)
-
-	return parser.Parameter{
-		Value: value,
-		Type:  parser.LITERAL,
-	}
+	return callback(&cpu.registers[register]), nil
 }
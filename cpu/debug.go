@@ -0,0 +1,122 @@
+package cpu
+
+// Hook is notified by a core before it starts an instruction, of the data
+// accesses of the instruction and once it is executed, along with its
+// address. The debugger pauses the machine inside it
+type Hook interface {
+	Starting(Instance, int)
+	Accessing(Instance, int, Access)
+	Executed(Instance, int)
+}
+
+// Access is a data access of an instruction to a bus address, whether it
+// is served by the caches or by the memory. The value is only known for
+// the writes
+type Access struct {
+	Address int
+	Write   bool
+	Value   int
+}
+
+// State is the architectural state of a core between two instructions,
+// the debugger takes it to move the core back in time
+type State struct {
+	PC        int
+	Flags     int
+	Registers []int
+	waiting   bool
+	halted    bool
+	stack     []frame
+}
+
+// starting notifies the hook of the instruction pointed by the program
+// counter, once until it is executed or abandoned. It tells if the
+// instruction may start, the hook may restore the core to another state
+func (cpu *cpu) starting() bool {
+	if cpu.hook == nil || cpu.announced == cpu.pc {
+		return true
+	}
+
+	pc := cpu.pc
+	cpu.announced = pc
+	cpu.hook.Starting(cpu, pc)
+
+	return cpu.announced == pc
+}
+
+// accessing notifies the hook of a data access of the instruction being
+// executed
+func (cpu *cpu) accessing(access Access) {
+	if cpu.hook != nil {
+		cpu.hook.Accessing(cpu, cpu.announced, access)
+	}
+}
+
+// finished notifies the hook of the instruction executed, the next
+// instruction is announced by starting
+func (cpu *cpu) finished() {
+	pc := cpu.announced
+	cpu.announced = -1
+
+	if cpu.hook != nil {
+		cpu.hook.Executed(cpu, pc)
+	}
+}
+
+// PC returns the address of the next instruction
+func (cpu *cpu) PC() int {
+	return cpu.pc
+}
+
+// Flags returns the flags register
+func (cpu *cpu) Flags() int {
+	return cpu.flags
+}
+
+// Registers returns a copy of the registers
+func (cpu *cpu) Registers() []int {
+	return append([]int{}, cpu.registers...)
+}
+
+// SetRegister writes a register, telling if it is writable. The ID
+// register is read-only
+func (cpu *cpu) SetRegister(index int, value int) bool {
+	if index < 0 || index >= len(cpu.registers)-1 {
+		return false
+	}
+
+	cpu.registers[index] = value
+
+	return true
+}
+
+// State returns a copy of the architectural state of the core
+func (cpu *cpu) State() State {
+	return State{
+		PC:        cpu.pc,
+		Flags:     cpu.flags,
+		Registers: cpu.Registers(),
+		waiting:   cpu.isWaitingForConditional,
+		halted:    cpu.isHalted,
+		stack:     append([]frame{}, cpu.stack...),
+	}
+}
+
+// Restore moves the core to a state it was in. The instruction in flight
+// is abandoned, the stores it already issued still reach the bus
+func (cpu *cpu) Restore(state State) {
+	cpu.abandon()
+
+	cpu.pc = state.PC
+	cpu.flags = state.Flags
+	cpu.isWaitingForConditional = state.waiting
+	cpu.isHalted = state.halted
+	cpu.stack = append([]frame{}, state.stack...)
+	copy(cpu.registers, state.Registers)
+}
+
+// Label returns the address of a label, once it was fetched
+func (cpu *cpu) Label(label int) (int, bool) {
+	address, ok := cpu.labels[label]
+	return address, ok
+}
@@ -0,0 +1,115 @@
+package cpu
+
+import (
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+)
+
+// NOLINE marks the frames pushed by exceptions, which are not serviced by
+// the interrupt controller
+const NOLINE = -1
+
+var exceptions = map[int]string{
+	interrupt.ILLEGAL: "Illegal instruction",
+	interrupt.DIVIDE:  "Division by zero",
+	interrupt.FAULT:   "Memory fault",
+}
+
+// frame holds the state saved when an interrupt or exception is serviced
+type frame struct {
+	pc    int
+	flags int
+	line  int
+}
+
+// trap is a synchronous exception raised by an instruction, it is returned
+// along the execution of the instruction and stops it
+type trap struct {
+	vector int
+}
+
+func (trap trap) Error() string {
+	return exceptions[trap.vector]
+}
+
+// raise returns the exception of a vector
+func raise(vector int) error {
+	return trap{vector: vector}
+}
+
+// except dispatches the exception returned by an instruction to its
+// handler, the errors which are not exceptions are illegal instructions.
+// The saved program counter points to the next instruction
+func (cpu *cpu) except(err error) {
+	t, ok := err.(trap)
+	if !ok {
+		t = trap{vector: interrupt.ILLEGAL}
+	}
+
+	cpu.log.Warn("exception", "cycle", cpu.bus.Kernel().Cycle(), "cause", t.Error(), "instruction", cpu.pc-1)
+	cpu.abandon()
+	cpu.isWaitingForConditional = false
+	cpu.dispatch(t.vector, NOLINE)
+}
+
+// isInterruptible tells if the core services the hardware lines, which
+// are only delivered to the core 0
+func (cpu *cpu) isInterruptible() bool {
+	return cpu.id == 0 && cpu.flags&FlagInterrupt != 0 && !cpu.isWaitingForConditional
+}
+
+// isLoaded tells if the handler of a vector can be dispatched, the vectors
+// without handler are dispatched to be discarded
+func (cpu *cpu) isLoaded(vector int) bool {
+	label, installed := cpu.interrupts.Vector(vector)
+	_, ok := cpu.labels[label]
+
+	return !installed || ok
+}
+
+// interrupt acknowledges a hardware line and dispatches it to its handler
+func (cpu *cpu) interrupt(line int) {
+	cpu.log.Debug("interrupt", "cycle", cpu.bus.Kernel().Cycle(), "line", line)
+	cpu.interrupts.Acknowledge(line)
+	cpu.dispatch(interrupt.IRQ+line, line)
+}
+
+// dispatch saves the program counter and the flags and jumps to the handler
+// of a vector with the interrupts disabled. Handlers may enable them again
+// to be preempted by lines of higher priority. The lines without handler
+// are discarded and the exceptions without handler halt the core
+func (cpu *cpu) dispatch(vector int, line int) {
+	label, installed := cpu.interrupts.Vector(vector)
+	address, ok := cpu.labels[label]
+
+	if !installed || !ok {
+		if line != NOLINE {
+			cpu.interrupts.Complete()
+			return
+		}
+
+		cpu.log.Error("unhandled exception", "cycle", cpu.bus.Kernel().Cycle(), "cause", exceptions[vector], "instruction", cpu.pc-1)
+		cpu.halt()
+		return
+	}
+
+	cpu.stack = append(cpu.stack, frame{pc: cpu.pc, flags: cpu.flags, line: line})
+	cpu.flags &^= FlagInterrupt
+	cpu.pc = address
+}
+
+func (cpu *cpu) iret() error {
+	if len(cpu.stack) == 0 {
+		return raise(interrupt.ILLEGAL)
+	}
+
+	top := cpu.stack[len(cpu.stack)-1]
+	cpu.stack = cpu.stack[:len(cpu.stack)-1]
+	cpu.pc = top.pc
+	cpu.flags = top.flags
+
+	if top.line != NOLINE {
+		cpu.interrupts.Complete()
+	}
+
+	return nil
+}
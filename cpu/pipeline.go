@@ -0,0 +1,500 @@
+package cpu
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/logger"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// This constants are the stages of the pipeline
+const (
+	// IF fetches the instruction through the instruction caches
+	IF = iota
+	// ID decodes the instruction, stalling on data hazards
+	ID
+	// EX loads the operands and executes the instruction
+	EX
+	// MEM waits for the stores of the instruction to be acknowledged
+	MEM
+	// WB retires the instruction
+	WB
+	// STAGES is the number of stages
+	STAGES
+)
+
+var stageNames = []string{"IF", "ID", "EX", "MEM", "WB"}
+
+// Pipeline configures the pipelined mode of the cpu. When it is disabled
+// the instructions are executed one at a time
+type Pipeline struct {
+	Enabled bool
+	// Forwarding feeds the results of the execute stage to the next
+	// instruction, only the results loaded from the memory stall it
+	Forwarding bool
+	// Diagram logs the instruction held by every stage once per cycle
+	Diagram bool
+}
+
+// PipelineStats holds the counters of the pipeline
+type PipelineStats struct {
+	// Stalls is the number of cycles the decode stage waited for a
+	// data hazard
+	Stalls int
+	// Flushes is the number of times the fetch was redirected
+	Flushes int
+	// Squashed is the number of instructions discarded
+	Squashed int
+}
+
+// slot is an instruction moving through the pipeline
+type slot struct {
+	pc          int
+	instruction parser.Action
+	delay       int
+	fetched     bool
+	decoded     bool
+	started     bool
+	executed    bool
+	ready       bool
+	branch      bool
+	predicted   bool
+	stores      [2]int
+	entered     int
+}
+
+type pipeline struct {
+	config   Pipeline
+	stages   [STAGES]*slot
+	next     int
+	skip     int
+	redirect bool
+	stats    PipelineStats
+}
+
+func newPipeline(config Pipeline) *pipeline {
+	if !config.Enabled {
+		return nil
+	}
+
+	return &pipeline{config: config, skip: -1}
+}
+
+func (pipeline *pipeline) empty() bool {
+	for _, stage := range pipeline.stages {
+		if stage != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Pipeline returns the counters of the pipeline, if it is enabled
+func (cpu *cpu) Pipeline() (PipelineStats, bool) {
+	if cpu.pipeline == nil {
+		return PipelineStats{}, false
+	}
+
+	return cpu.pipeline.stats, true
+}
+
+// advance runs a cycle of the pipeline. Every stage works on its
+// instruction, from the last to the first one, then the instructions ready
+// move to the next stage. The instructions are executed in order by the
+// execute stage, the hazards only delay them
+func (cpu *cpu) advance() {
+	if cpu.isHalted || !cpu.boot() {
+		return
+	}
+
+	cpu.work()
+
+	if cpu.pipeline.config.Diagram && !cpu.pipeline.empty() {
+		cpu.diagram()
+	}
+
+	cpu.move()
+}
+
+// work runs the stages from the last one, the stages before the execute
+// stage wait for the next cycle when it raises an exception
+func (cpu *cpu) work() {
+	cpu.writebackStage()
+	cpu.memoryStage()
+
+	if err := cpu.executeStage(); err != nil {
+		cpu.except(err)
+		return
+	}
+
+	cpu.decodeStage()
+	cpu.fetchStage()
+}
+
+func (cpu *cpu) writebackStage() {
+	if wb := cpu.pipeline.stages[WB]; wb != nil {
+		wb.ready = true
+	}
+}
+
+// memoryStage waits for the stores issued by the instruction
+func (cpu *cpu) memoryStage() {
+	mem := cpu.pipeline.stages[MEM]
+	if mem == nil {
+		return
+	}
+
+	for key := range cpu.stores {
+		if key >= mem.stores[0] && key < mem.stores[1] {
+			return
+		}
+	}
+
+	mem.ready = true
+}
+
+// executeStage services the pending interrupts before an instruction
+// starts, discards the instructions of a wrong path and executes the others
+// once their operands are loaded. The fetch is redirected when the program
+// counter does not follow the instructions in flight
+func (cpu *cpu) executeStage() error {
+	ex := cpu.pipeline.stages[EX]
+	if ex == nil {
+		return nil
+	}
+
+	if cpu.busy > 0 {
+		cpu.busy--
+		ex.ready = ex.executed && cpu.busy == 0
+		return nil
+	}
+
+	if !ex.started {
+		if started, err := cpu.start(ex); !started || err != nil {
+			return err
+		}
+	}
+
+	if !ex.executed {
+		if len(cpu.pending) > 0 || len(cpu.misses) > 0 {
+			return nil
+		}
+
+		ex.stores[0] = cpu.key
+		if err := cpu.execute(); err != nil {
+			return err
+		}
+
+		// The debugger restored the core, the instruction was squashed
+		if cpu.pipeline.stages[EX] != ex {
+			return nil
+		}
+
+		ex.stores[1] = cpu.key
+		ex.executed = true
+
+		// The instruction after a conditional not holding is skipped
+		if cpu.isWaitingForConditional && cpu.flags&FlagCondition == 0 {
+			cpu.isWaitingForConditional = false
+			cpu.pc++
+		}
+
+		if cpu.expected() != cpu.pc {
+			cpu.redirect()
+		}
+
+		if cpu.busy > 0 {
+			return nil
+		}
+	}
+
+	ex.ready = true
+
+	return nil
+}
+
+// start begins the execution of an instruction the way step does, telling
+// if it was not discarded or returning the exception it raised
+func (cpu *cpu) start(ex *slot) (bool, error) {
+	if cpu.isInterruptible() {
+		if line, ok := cpu.interrupts.Pending(); ok && cpu.isLoaded(interrupt.IRQ+line) {
+			cpu.interrupt(line)
+			cpu.redirect()
+		}
+	}
+
+	if ex.pc != cpu.pc {
+		cpu.squash(EX)
+		cpu.redirect()
+		return false, nil
+	}
+
+	instruction := ex.instruction
+
+	// The program is still being loaded, wait for the label to be fetched
+	if instruction.Action == parser.Jump && instruction.Location.Type == parser.LITERAL {
+		if _, ok := cpu.labels[instruction.Location.Value]; !ok {
+			return false, nil
+		}
+	}
+
+	// The debugger restored the core, the instruction was squashed
+	if !cpu.starting() {
+		return false, nil
+	}
+
+	if !cpu.acquire(instruction) {
+		return false, nil
+	}
+
+	cpu.pc++
+
+	// The conditional before this instruction held, the next one is skipped
+	if cpu.isWaitingForConditional {
+		cpu.isWaitingForConditional = false
+		cpu.pc++
+	}
+
+	if ex.branch {
+		cpu.guess = &prediction{pc: ex.pc, taken: ex.predicted}
+	}
+
+	ex.started = true
+	cpu.current = &instruction
+
+	return true, cpu.load(instruction)
+}
+
+// decodeStage decodes the instruction and checks it against the results
+// not written back yet
+func (cpu *cpu) decodeStage() {
+	id := cpu.pipeline.stages[ID]
+	if id == nil {
+		return
+	}
+
+	if !id.decoded {
+		id.instruction = cpu.decoder.Decode(cpu.executionMap[id.pc])
+		id.decoded = true
+	}
+
+	id.ready = false
+
+	if cpu.hazard(id.instruction) {
+		if ex := cpu.pipeline.stages[EX]; ex == nil || ex.ready {
+			cpu.pipeline.stats.Stalls++
+		}
+
+		return
+	}
+
+	id.ready = true
+}
+
+// fetchStage reads the instruction through the instruction caches, the
+// latency of the caches is spent in this stage
+func (cpu *cpu) fetchStage() {
+	fetch := cpu.pipeline.stages[IF]
+	if fetch == nil {
+		return
+	}
+
+	if !fetch.fetched {
+		busy := cpu.busy
+		fetch.fetched = cpu.fetchInstruction(fetch.pc)
+		fetch.delay += cpu.busy - busy
+		cpu.busy = busy
+
+		if !fetch.fetched {
+			return
+		}
+
+		// The first cycle of the latency is the one of this stage
+		fetch.delay--
+	}
+
+	if fetch.delay > 0 {
+		fetch.delay--
+		return
+	}
+
+	fetch.ready = true
+}
+
+// move advances the instructions ready into the free stages and fetches
+// the next instruction
+func (cpu *cpu) move() {
+	pipeline := cpu.pipeline
+	stages := &pipeline.stages
+
+	if pipeline.redirect {
+		cpu.realign()
+		pipeline.redirect = false
+	}
+
+	if stages[WB] != nil && stages[WB].ready {
+		cpu.leave(WB, stages[WB], false)
+		stages[WB] = nil
+	}
+
+	for stage := WB; stage > IF; stage-- {
+		previous := stages[stage-1]
+
+		if stages[stage] == nil && previous != nil && previous.ready {
+			previous.ready = false
+			cpu.leave(stage-1, previous, false)
+			stages[stage] = previous
+			stages[stage-1] = nil
+		}
+	}
+
+	if stages[IF] == nil && cpu.executionMap[pipeline.next] != nil {
+		stages[IF] = &slot{pc: pipeline.next, entered: cpu.bus.Kernel().Cycle() + 1}
+		pipeline.next = cpu.predict(stages[IF])
+	}
+}
+
+// realign discards the instructions in flight until the one pointed by the
+// program counter, fetching it again when it was not in flight
+func (cpu *cpu) realign() {
+	for _, stage := range []int{ID, IF} {
+		current := cpu.pipeline.stages[stage]
+
+		if current == nil {
+			continue
+		}
+
+		if current.pc == cpu.pc {
+			return
+		}
+
+		cpu.squash(stage)
+	}
+
+	cpu.pipeline.next = cpu.pc
+	cpu.pipeline.skip = -1
+}
+
+// expected returns the address of the next instruction in flight
+func (cpu *cpu) expected() int {
+	for _, stage := range []int{ID, IF} {
+		if cpu.pipeline.stages[stage] != nil {
+			return cpu.pipeline.stages[stage].pc
+		}
+	}
+
+	return cpu.pipeline.next
+}
+
+// redirect realigns the instructions in flight with the program counter at
+// the end of the cycle
+func (cpu *cpu) redirect() {
+	if !cpu.pipeline.redirect {
+		cpu.pipeline.redirect = true
+		cpu.pipeline.stats.Flushes++
+	}
+}
+
+func (cpu *cpu) squash(stage int) {
+	if cpu.pipeline.stages[stage] != nil {
+		cpu.leave(stage, cpu.pipeline.stages[stage], true)
+		cpu.pipeline.stages[stage] = nil
+		cpu.pipeline.stats.Squashed++
+	}
+}
+
+// hazard tells if an instruction reads a register written by an
+// instruction in the execute or memory stages. With forwarding only the
+// results of the execute stage loaded from the memory are not available
+func (cpu *cpu) hazard(instruction parser.Action) bool {
+	for _, stage := range []int{EX, MEM} {
+		producer := cpu.pipeline.stages[stage]
+		if producer == nil {
+			continue
+		}
+
+		register, ok := writes(producer.instruction)
+		if !ok || !reads(instruction, register) {
+			continue
+		}
+
+		if !cpu.pipeline.config.Forwarding || stage == EX && loadsMemory(producer.instruction) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writes returns the register written by an instruction
+func writes(instruction parser.Action) (int, bool) {
+	switch instruction.Action {
+	case parser.Mov, parser.Add, parser.Inc, parser.Imul, parser.Idiv:
+		return instruction.Location.Value, instruction.Location.Type == parser.REGISTER
+	case parser.Xchg, parser.Cmpxchg, parser.Xadd:
+		if len(instruction.Parameters) > 0 {
+			return instruction.Parameters[0].Value, instruction.Parameters[0].Type == parser.REGISTER
+		}
+	}
+
+	return 0, false
+}
+
+// reads tells if an instruction reads a register
+func reads(instruction parser.Action, register int) bool {
+	for _, parameter := range instruction.Parameters {
+		if parameter.Type == parser.REGISTER && parameter.Value == register {
+			return true
+		}
+	}
+
+	location := instruction.Location
+	readsLocation := instruction.Action == parser.Add || instruction.Action == parser.Inc || isConditional(instruction.Action)
+
+	return readsLocation && location.Type == parser.REGISTER && location.Value == register
+}
+
+// loadsMemory tells if an instruction reads an operand from the memory
+func loadsMemory(instruction parser.Action) bool {
+	if isAtomic(instruction.Action) {
+		return true
+	}
+
+	for _, parameter := range instruction.Parameters {
+		if parameter.Type == parser.MEMORY {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diagram logs the instruction held by every stage, the stalled ones are
+// marked with an asterisk. The stages are not described when the message
+// would be discarded
+func (cpu *cpu) diagram() {
+	if !cpu.log.Enabled(logger.INFO) {
+		return
+	}
+
+	columns := make([]string, 0)
+
+	for stage, current := range cpu.pipeline.stages {
+		column := "--"
+
+		if current != nil {
+			column = strconv.Itoa(current.pc)
+
+			if !current.ready {
+				column += "*"
+			}
+		}
+
+		columns = append(columns, stageNames[stage]+" "+column)
+	}
+
+	cpu.log.Info("pipeline", "cycle", cpu.bus.Kernel().Cycle(), "stages", strings.Join(columns, " | "))
+}
@@ -0,0 +1,83 @@
+package cpu
+
+import (
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// prediction is the outcome predicted for the branch being executed
+type prediction struct {
+	pc    int
+	taken bool
+}
+
+// isBranch tells if an instruction is predicted. The jumps to a label not
+// fetched yet or to an address held by a register or the memory are not
+func (cpu *cpu) isBranch(instruction parser.Action) bool {
+	if isConditional(instruction.Action) {
+		return true
+	}
+
+	if instruction.Action == parser.Jump && instruction.Location.Type == parser.LITERAL {
+		_, ok := cpu.labels[instruction.Location.Value]
+		return ok
+	}
+
+	return false
+}
+
+// resolve trains the predictor with the outcome of the branch executed, a
+// misprediction costs the penalty of the predictor
+func (cpu *cpu) resolve(instruction parser.Action) {
+	guess := cpu.guess
+	cpu.guess = nil
+
+	if guess == nil {
+		return
+	}
+
+	taken := instruction.Action == parser.Jump || cpu.flags&FlagCondition != 0
+
+	if cpu.predictor.Resolve(guess.pc, guess.taken, taken) {
+		cpu.busy += cpu.predictor.Penalty()
+	}
+}
+
+// predict returns the address fetched after an instruction entering the
+// pipeline. A conditional predicted to hold is followed by the next
+// instruction only, one predicted not to hold by the one after it
+func (cpu *cpu) predict(fetch *slot) int {
+	next := fetch.pc + 1
+
+	if cpu.pipeline.skip == next {
+		next++
+	}
+
+	cpu.pipeline.skip = -1
+
+	if cpu.predictor == nil {
+		return next
+	}
+
+	instruction := cpu.decoder.Decode(cpu.executionMap[fetch.pc])
+	if !cpu.isBranch(instruction) {
+		return next
+	}
+
+	fetch.branch = true
+	fetch.predicted = cpu.predictor.Predict(fetch.pc)
+
+	if !fetch.predicted {
+		if isConditional(instruction.Action) {
+			return fetch.pc + 2
+		}
+
+		return next
+	}
+
+	if isConditional(instruction.Action) {
+		cpu.pipeline.skip = fetch.pc + 2
+		return fetch.pc + 1
+	}
+
+	return cpu.labels[instruction.Location.Value]
+}
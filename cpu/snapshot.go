@@ -0,0 +1,368 @@
+package cpu
+
+import (
+	"sort"
+
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// Snapshot is the state of a core, its caches and its predictor are saved
+// apart. The lines being refilled name their cache level
+type Snapshot struct {
+	Entry        int
+	PI           int
+	PC           int
+	Flags        int
+	Busy         int
+	Fetching     int
+	Fetched      int
+	Retired      int
+	Halted       bool
+	Waiting      bool
+	Registers    []int
+	Stack        []Frame
+	Key          int
+	Labels       map[int]int
+	Loads        map[int]int
+	Pending      map[int]int
+	Stores       map[int]int
+	Locked       bool
+	Announced    int
+	FetchStart   int
+	DecodeCycle  int
+	DecodePC     int
+	Misses       map[int]int
+	Refills      []Refill
+	Fills        map[int]Fill
+	Current      *parser.Action
+	Guess        *Guess
+	MessageQueue []parser.Msg
+	Pipeline     *Stages
+	ExecutionMap map[int][]parser.Msg
+}
+
+// Frame is an entry of the interrupt stack
+type Frame struct {
+	PC    int
+	Flags int
+	Line  int
+}
+
+// Refill is a line being refilled into a cache level
+type Refill struct {
+	Level     string
+	Base      int
+	Words     []int
+	Remaining int
+	Waiters   []Waiter
+	Shared    bool
+	Stale     bool
+}
+
+// Waiter is an access waiting for a refill, from its first cache level
+type Waiter struct {
+	Level   string
+	Address int
+}
+
+// Fill is a word of a refill, which is given by its position among the
+// refills of the snapshot
+type Fill struct {
+	Refill  int
+	Address int
+}
+
+// Guess is the prediction of the branch being fetched
+type Guess struct {
+	PC    int
+	Taken bool
+}
+
+// Stages is the state of the pipeline, the empty stages are nil
+type Stages struct {
+	Slots    [STAGES]*Slot
+	Next     int
+	Skip     int
+	Redirect bool
+	Stats    PipelineStats
+}
+
+// Slot is an instruction moving through the pipeline
+type Slot struct {
+	PC          int
+	Instruction parser.Action
+	Delay       int
+	Fetched     bool
+	Decoded     bool
+	Started     bool
+	Executed    bool
+	Ready       bool
+	Branch      bool
+	Predicted   bool
+	Stores      [2]int
+	Entered     int
+}
+
+// levelName returns the name of a cache level of the core
+func (cpu *cpu) levelName(level cache.Instance) string {
+	switch {
+	case level == nil:
+		return ""
+	case level == cpu.caches.Instruction:
+		return "instruction"
+	case level == cpu.caches.Data:
+		return "data"
+	default:
+		return "unified"
+	}
+}
+
+// level returns the cache level of the core with the given name
+func (cpu *cpu) level(name string) cache.Instance {
+	switch name {
+	case "instruction":
+		return cpu.caches.Instruction
+	case "data":
+		return cpu.caches.Data
+	case "unified":
+		return cpu.caches.Unified
+	}
+
+	return nil
+}
+
+// Snapshot returns the state of the core
+func (cpu *cpu) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Entry:        cpu.entry,
+		PI:           cpu.pi,
+		PC:           cpu.pc,
+		Flags:        cpu.flags,
+		Busy:         cpu.busy,
+		Fetching:     cpu.fetching,
+		Fetched:      cpu.fetched,
+		Retired:      cpu.retired,
+		Halted:       cpu.isHalted,
+		Waiting:      cpu.isWaitingForConditional,
+		Registers:    cpu.Registers(),
+		Stack:        make([]Frame, len(cpu.stack)),
+		Key:          cpu.key,
+		Labels:       copyMap(cpu.labels),
+		Loads:        copyMap(cpu.loads),
+		Pending:      copyMap(cpu.pending),
+		Stores:       copyMap(cpu.stores),
+		Locked:       cpu.locked,
+		Announced:    cpu.announced,
+		FetchStart:   cpu.fetchStart,
+		DecodeCycle:  cpu.decodeCycle,
+		DecodePC:     cpu.decodePC,
+		Misses:       copyMap(cpu.misses),
+		Refills:      make([]Refill, 0, len(cpu.refills)),
+		Fills:        make(map[int]Fill),
+		MessageQueue: append([]parser.Msg{}, cpu.messageQueue...),
+		ExecutionMap: make(map[int][]parser.Msg),
+	}
+
+	for index, current := range cpu.stack {
+		snapshot.Stack[index] = Frame{PC: current.pc, Flags: current.flags, Line: current.line}
+	}
+
+	refills := make([]*refill, 0, len(cpu.refills))
+	for _, pending := range cpu.refills {
+		refills = append(refills, pending)
+	}
+
+	sort.Slice(refills, func(i int, j int) bool {
+		left, right := cpu.levelName(refills[i].bottom), cpu.levelName(refills[j].bottom)
+		if left == right {
+			return refills[i].base < refills[j].base
+		}
+
+		return left < right
+	})
+
+	positions := make(map[*refill]int)
+	for index, pending := range refills {
+		positions[pending] = index
+
+		saved := Refill{
+			Level:     cpu.levelName(pending.bottom),
+			Base:      pending.base,
+			Words:     append([]int{}, pending.words...),
+			Remaining: pending.remaining,
+			Waiters:   make([]Waiter, len(pending.waiters)),
+			Shared:    pending.shared,
+			Stale:     pending.stale,
+		}
+
+		for position, waiting := range pending.waiters {
+			saved.Waiters[position] = Waiter{Level: cpu.levelName(waiting.first), Address: waiting.address}
+		}
+
+		snapshot.Refills = append(snapshot.Refills, saved)
+	}
+
+	for key, word := range cpu.fills {
+		snapshot.Fills[key] = Fill{Refill: positions[word.refill], Address: word.address}
+	}
+
+	if cpu.current != nil {
+		current := *cpu.current
+		snapshot.Current = &current
+	}
+
+	if cpu.guess != nil {
+		snapshot.Guess = &Guess{PC: cpu.guess.pc, Taken: cpu.guess.taken}
+	}
+
+	for pc, message := range cpu.executionMap {
+		snapshot.ExecutionMap[pc] = append([]parser.Msg{}, message...)
+	}
+
+	if cpu.pipeline != nil {
+		stages := &Stages{
+			Next:     cpu.pipeline.next,
+			Skip:     cpu.pipeline.skip,
+			Redirect: cpu.pipeline.redirect,
+			Stats:    cpu.pipeline.stats,
+		}
+
+		for stage, current := range cpu.pipeline.stages {
+			if current != nil {
+				stages.Slots[stage] = &Slot{
+					PC:          current.pc,
+					Instruction: current.instruction,
+					Delay:       current.delay,
+					Fetched:     current.fetched,
+					Decoded:     current.decoded,
+					Started:     current.started,
+					Executed:    current.executed,
+					Ready:       current.ready,
+					Branch:      current.branch,
+					Predicted:   current.predicted,
+					Stores:      current.stores,
+					Entered:     current.entered,
+				}
+			}
+		}
+
+		snapshot.Pipeline = stages
+	}
+
+	return snapshot
+}
+
+// LoadSnapshot moves the core to a saved state, its caches and its
+// predictor are restored apart
+func (cpu *cpu) LoadSnapshot(snapshot Snapshot) {
+	cpu.entry = snapshot.Entry
+	cpu.pi = snapshot.PI
+	cpu.pc = snapshot.PC
+	cpu.flags = snapshot.Flags
+	cpu.busy = snapshot.Busy
+	cpu.fetching = snapshot.Fetching
+	cpu.fetched = snapshot.Fetched
+	cpu.retired = snapshot.Retired
+	cpu.isHalted = snapshot.Halted
+	cpu.isWaitingForConditional = snapshot.Waiting
+	cpu.key = snapshot.Key
+	cpu.labels = copyMap(snapshot.Labels)
+	cpu.loads = copyMap(snapshot.Loads)
+	cpu.pending = copyMap(snapshot.Pending)
+	cpu.stores = copyMap(snapshot.Stores)
+	cpu.locked = snapshot.Locked
+	cpu.announced = snapshot.Announced
+	cpu.fetchStart = snapshot.FetchStart
+	cpu.decodeCycle = snapshot.DecodeCycle
+	cpu.decodePC = snapshot.DecodePC
+	cpu.misses = copyMap(snapshot.Misses)
+	cpu.messageQueue = append([]parser.Msg{}, snapshot.MessageQueue...)
+	copy(cpu.registers, snapshot.Registers)
+
+	cpu.stack = make([]frame, len(snapshot.Stack))
+	for index, saved := range snapshot.Stack {
+		cpu.stack[index] = frame{pc: saved.PC, flags: saved.Flags, line: saved.Line}
+	}
+
+	refills := make([]*refill, len(snapshot.Refills))
+	cpu.refills = make(map[line]*refill)
+
+	for index, saved := range snapshot.Refills {
+		pending := &refill{
+			base:      saved.Base,
+			words:     append([]int{}, saved.Words...),
+			remaining: saved.Remaining,
+			bottom:    cpu.level(saved.Level),
+			waiters:   make([]waiter, len(saved.Waiters)),
+			shared:    saved.Shared,
+			stale:     saved.Stale,
+		}
+
+		for position, waiting := range saved.Waiters {
+			pending.waiters[position] = waiter{first: cpu.level(waiting.Level), address: waiting.Address}
+		}
+
+		refills[index] = pending
+		cpu.refills[line{level: pending.bottom, base: pending.base}] = pending
+	}
+
+	cpu.fills = make(map[int]fill)
+	for key, word := range snapshot.Fills {
+		cpu.fills[key] = fill{refill: refills[word.Refill], address: word.Address}
+	}
+
+	cpu.current = nil
+	if snapshot.Current != nil {
+		current := *snapshot.Current
+		cpu.current = &current
+	}
+
+	cpu.guess = nil
+	if snapshot.Guess != nil {
+		cpu.guess = &prediction{pc: snapshot.Guess.PC, taken: snapshot.Guess.Taken}
+	}
+
+	cpu.executionMap = make(map[int][]parser.Msg)
+	for pc, message := range snapshot.ExecutionMap {
+		cpu.executionMap[pc] = append([]parser.Msg{}, message...)
+	}
+
+	if cpu.pipeline != nil && snapshot.Pipeline != nil {
+		cpu.pipeline.next = snapshot.Pipeline.Next
+		cpu.pipeline.skip = snapshot.Pipeline.Skip
+		cpu.pipeline.redirect = snapshot.Pipeline.Redirect
+		cpu.pipeline.stats = snapshot.Pipeline.Stats
+
+		for stage, saved := range snapshot.Pipeline.Slots {
+			cpu.pipeline.stages[stage] = nil
+
+			if saved != nil {
+				cpu.pipeline.stages[stage] = &slot{
+					pc:          saved.PC,
+					instruction: saved.Instruction,
+					delay:       saved.Delay,
+					fetched:     saved.Fetched,
+					decoded:     saved.Decoded,
+					started:     saved.Started,
+					executed:    saved.Executed,
+					ready:       saved.Ready,
+					branch:      saved.Branch,
+					predicted:   saved.Predicted,
+					stores:      saved.Stores,
+					entered:     saved.Entered,
+				}
+			}
+		}
+	}
+}
+
+func copyMap(values map[int]int) map[int]int {
+	copied := make(map[int]int, len(values))
+
+	for key, value := range values {
+		copied[key] = value
+	}
+
+	return copied
+}
@@ -0,0 +1,81 @@
+package cpu
+
+import (
+	"strconv"
+
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// activity records an activity of the core on the timeline, until the end
+// of the current cycle
+func (cpu *cpu) activity(track string, instruction parser.Action, pc int, start int) {
+	if cpu.timeline == nil {
+		return
+	}
+
+	cpu.timeline.Span(cpu.name, track, label(instruction, pc), start, cpu.bus.Kernel().Cycle()+1)
+}
+
+// label names an instruction on the timeline after its action and address
+func label(instruction parser.Action, pc int) string {
+	return parser.Mnemonic(instruction.Action) + " @" + strconv.Itoa(pc)
+}
+
+// fetchStarted remembers the cycle the fetch of an instruction started
+func (cpu *cpu) fetchStarted() {
+	if cpu.fetchStart < 0 {
+		cpu.fetchStart = cpu.bus.Kernel().Cycle()
+	}
+}
+
+// decoded records the fetch and the decode of an instruction executed one
+// at a time
+func (cpu *cpu) decoded(instruction parser.Action, pc int) {
+	cycle := cpu.bus.Kernel().Cycle()
+
+	cpu.activity("fetch", instruction, pc, cpu.fetchStart)
+	cpu.activity("decode", instruction, pc, cycle)
+
+	cpu.fetchStart = -1
+	cpu.decodeCycle = cycle
+	cpu.decodePC = pc
+}
+
+// executed records the wait for the operands and the execution of an
+// instruction executed one at a time, the execution lasts its cost
+func (cpu *cpu) executed(instruction parser.Action) {
+	if cpu.timeline == nil || cpu.pipeline != nil {
+		return
+	}
+
+	cycle := cpu.bus.Kernel().Cycle()
+	name := label(instruction, cpu.decodePC)
+
+	if cycle > cpu.decodeCycle {
+		cpu.timeline.Span(cpu.name, "memory", name, cpu.decodeCycle+1, cycle)
+	}
+
+	cpu.timeline.Span(cpu.name, "execute", name, cycle, cycle+cpu.busy+1)
+}
+
+// leave records the cycles an instruction spent in a stage of the
+// pipeline, a squashed one is marked
+func (cpu *cpu) leave(stage int, current *slot, squashed bool) {
+	if cpu.timeline == nil {
+		return
+	}
+
+	instruction := current.instruction
+	if !current.decoded {
+		instruction = cpu.decoder.Decode(cpu.executionMap[current.pc])
+	}
+
+	name := label(instruction, current.pc)
+	if squashed {
+		name += " (squashed)"
+	}
+
+	end := cpu.bus.Kernel().Cycle() + 1
+	cpu.timeline.Span(cpu.name, stageNames[stage], name, current.entered, end)
+	current.entered = end
+}
@@ -0,0 +1,581 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/io"
+	"github.com/bruunoromero/cpu-emulator/sim"
+)
+
+// request is a request of the Debug Adapter Protocol
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// source is a source file of the Debug Adapter Protocol
+type source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// This constants are the kinds of variable references, every core has one
+// reference of each kind
+const (
+	REGISTERS = iota + 1
+	MEMORY
+	REFERENCES
+)
+
+// adapter serves the Debug Adapter Protocol over a TCP connection, for the
+// editors. The cores are the threads of the program, numbered from 1, and
+// every thread has a single stack frame numbered like it. The program is
+// given by the launch request, the machine starts once the configuration
+// is done
+type adapter struct {
+	debugger    *debugger
+	conn        net.Conn
+	requests    chan request
+	mutex       sync.Mutex
+	seq         int
+	stopOnEntry bool
+}
+
+// Serve returns a new instance of the debugger serving the Debug Adapter
+// Protocol on a TCP address. It returns once an editor connected, launched
+// the program and set its breakpoints
+func Serve(address string, kernel sim.Instance, bus b.Instance, program io.Instance, registers []string, word int) (Instance, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer listener.Close()
+
+	fmt.Println("Waiting for the editor on", listener.Addr())
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	debugger := newDebugger(kernel, bus, program, registers, word)
+	adapter := &adapter{debugger: debugger, conn: conn, requests: make(chan request, 16)}
+	debugger.frontend = adapter
+
+	go adapter.receive()
+
+	for current := range adapter.requests {
+		if adapter.handle(current) {
+			return debugger, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the editor disconnected before the configuration was done")
+}
+
+// receive reads the requests of the editor. The pause, the threads and the
+// end of the session are served while the machine runs, the other
+// requests are queued to be served while it is paused
+func (adapter *adapter) receive() {
+	defer close(adapter.requests)
+
+	reader := bufio.NewReader(adapter.conn)
+	kernel := adapter.debugger.kernel
+
+	for {
+		current, err := adapter.read(reader)
+		if err != nil {
+			return
+		}
+
+		switch current.Command {
+		case "pause":
+			adapter.respond(current, nil)
+			kernel.Post(adapter.debugger.interrupt)
+		case "threads":
+			adapter.respond(current, adapter.threads())
+		case "disconnect", "terminate":
+			adapter.respond(current, nil)
+			kernel.Post(func() {
+				adapter.debugger.detached = true
+				kernel.Stop()
+			})
+
+			adapter.requests <- current
+
+			if current.Command == "disconnect" {
+				return
+			}
+		default:
+			adapter.requests <- current
+		}
+	}
+}
+
+// read reads a message framed by its Content-Length header
+func (adapter *adapter) read(reader *bufio.Reader) (request, error) {
+	length := 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return request{}, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+
+		if strings.HasPrefix(line, "Content-Length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+			if err != nil {
+				return request{}, err
+			}
+		}
+	}
+
+	body := make([]byte, length)
+	for read := 0; read < length; {
+		count, err := reader.Read(body[read:])
+		if err != nil {
+			return request{}, err
+		}
+
+		read += count
+	}
+
+	current := request{}
+	err := json.Unmarshal(body, &current)
+
+	return current, err
+}
+
+// write numbers a message and sends it, the messages are written by the
+// kernel and by the reader of the requests
+func (adapter *adapter) write(message func(int) interface{}) {
+	adapter.mutex.Lock()
+	defer adapter.mutex.Unlock()
+
+	adapter.seq++
+
+	data, err := json.Marshal(message(adapter.seq))
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(adapter.conn, "Content-Length: %d\r\n\r\n%s", len(data), data)
+}
+
+func (adapter *adapter) respond(current request, body interface{}) {
+	adapter.write(func(seq int) interface{} {
+		return response{Seq: seq, Type: "response", RequestSeq: current.Seq, Success: true, Command: current.Command, Body: body}
+	})
+}
+
+func (adapter *adapter) fail(current request, message string) {
+	adapter.write(func(seq int) interface{} {
+		return response{Seq: seq, Type: "response", RequestSeq: current.Seq, Command: current.Command, Message: message}
+	})
+}
+
+func (adapter *adapter) event(name string, body interface{}) {
+	adapter.write(func(seq int) interface{} {
+		return event{Seq: seq, Type: "event", Event: name, Body: body}
+	})
+}
+
+// paused tells the editor why the machine stopped, unless it starts
+// without stopping on entry, and serves the requests until one of them
+// resumes the machine. The debugger is detached once the editor leaves
+func (adapter *adapter) paused(current stop) {
+	if current.reason == "start" && !adapter.stopOnEntry {
+		return
+	}
+
+	adapter.stopped(current)
+
+	for request := range adapter.requests {
+		if adapter.handle(request) {
+			return
+		}
+	}
+
+	adapter.debugger.detached = true
+}
+
+// stopped tells the editor where the machine is paused
+func (adapter *adapter) stopped(current stop) {
+	adapter.event("stopped", map[string]interface{}{
+		"reason":            adapter.reason(current),
+		"description":       current.reason,
+		"threadId":          adapter.debugger.current + 1,
+		"allThreadsStopped": true,
+	})
+}
+
+// reason returns the reason of a stopped event
+func (adapter *adapter) reason(current stop) string {
+	switch {
+	case current.reason == "start":
+		return "entry"
+	case current.signal == SIGINT:
+		return "pause"
+	case current.hit == nil:
+		return "step"
+	case isBreakpoint(current.hit.kind):
+		return "breakpoint"
+	}
+
+	return "data breakpoint"
+}
+
+// close tells the editor the program exited
+func (adapter *adapter) close() {
+	adapter.event("exited", map[string]interface{}{"exitCode": 0})
+	adapter.event("terminated", nil)
+	adapter.conn.Close()
+}
+
+// handle serves a request, telling if the machine must start or resume
+func (adapter *adapter) handle(current request) bool {
+	debugger := adapter.debugger
+
+	switch current.Command {
+	case "initialize":
+		adapter.respond(current, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsReadMemoryRequest":        true,
+			"supportsTerminateRequest":         true,
+			"supportsStepBack":                 true,
+		})
+		adapter.event("initialized", nil)
+	case "launch", "attach":
+		adapter.launch(current)
+	case "setBreakpoints":
+		adapter.setBreakpoints(current)
+	case "setExceptionBreakpoints":
+		adapter.respond(current, map[string]interface{}{"breakpoints": []interface{}{}})
+	case "configurationDone":
+		adapter.respond(current, nil)
+		return true
+	case "stackTrace":
+		adapter.stackTrace(current)
+	case "scopes":
+		adapter.scopes(current)
+	case "variables":
+		adapter.variables(current)
+	case "readMemory":
+		adapter.readMemory(current)
+	case "continue":
+		adapter.respond(current, map[string]interface{}{"allThreadsContinued": true})
+		return true
+	case "next", "stepIn", "stepOut":
+		adapter.thread(current)
+		debugger.steps = 1
+		adapter.respond(current, nil)
+		return true
+	case "stepBack", "reverseContinue":
+		adapter.reverse(current)
+	case "disconnect", "terminate":
+		return true
+	default:
+		adapter.fail(current, "Unsupported request "+current.Command)
+	}
+
+	return false
+}
+
+// thread selects the core of the thread of a request
+func (adapter *adapter) thread(current request) {
+	var arguments struct {
+		ThreadID int `json:"threadId"`
+	}
+
+	json.Unmarshal(current.Arguments, &arguments)
+
+	if arguments.ThreadID >= 1 && arguments.ThreadID <= len(adapter.debugger.cores) {
+		adapter.debugger.current = arguments.ThreadID - 1
+	}
+}
+
+// reverse steps or continues backwards through the history, the machine
+// stays paused where it arrives
+func (adapter *adapter) reverse(current request) {
+	adapter.thread(current)
+
+	rewind := adapter.debugger.stepBack
+	if current.Command == "reverseContinue" {
+		rewind = adapter.debugger.reverseContinue
+	}
+
+	reached, ok := rewind()
+	if !ok {
+		adapter.fail(current, "The instruction is halfway, step it before going back")
+		return
+	}
+
+	adapter.respond(current, nil)
+	adapter.stopped(reached)
+}
+
+// launch loads the program given by the editor, the program of the
+// current directory is run when none is given
+func (adapter *adapter) launch(current request) {
+	var arguments struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+
+	json.Unmarshal(current.Arguments, &arguments)
+	adapter.stopOnEntry = arguments.StopOnEntry
+
+	if arguments.Program != "" {
+		if err := adapter.debugger.program.Load(arguments.Program); err != nil {
+			adapter.fail(current, err.Error())
+			return
+		}
+	}
+
+	adapter.respond(current, nil)
+}
+
+// setBreakpoints replaces the breakpoints on the source lines. The lines
+// without instructions are moved to the next line having them
+func (adapter *adapter) setBreakpoints(current request) {
+	var arguments struct {
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+
+	json.Unmarshal(current.Arguments, &arguments)
+
+	debugger := adapter.debugger
+	kept := make([]breakpoint, 0)
+
+	for _, existing := range debugger.breakpoints {
+		if existing.kind != LINE {
+			kept = append(kept, existing)
+		}
+	}
+
+	debugger.breakpoints = kept
+	results := make([]map[string]interface{}, 0)
+
+	for _, requested := range arguments.Breakpoints {
+		line, ok := adapter.line(requested.Line)
+		if !ok {
+			results = append(results, map[string]interface{}{"verified": false, "line": requested.Line, "message": "No instructions from this line"})
+			continue
+		}
+
+		inserted := debugger.insert(breakpoint{kind: LINE, value: line})
+		results = append(results, map[string]interface{}{"id": inserted.id, "verified": true, "line": line})
+	}
+
+	adapter.respond(current, map[string]interface{}{"breakpoints": results})
+}
+
+// line returns the first line having instructions from a line on
+func (adapter *adapter) line(requested int) (int, bool) {
+	for _, statement := range adapter.debugger.program.Source() {
+		if statement.Line >= requested {
+			return statement.Line, true
+		}
+	}
+
+	return 0, false
+}
+
+func (adapter *adapter) threads() map[string]interface{} {
+	threads := make([]map[string]interface{}, 0)
+
+	for index, current := range adapter.debugger.cores {
+		threads = append(threads, map[string]interface{}{"id": index + 1, "name": current.instance.Name()})
+	}
+
+	return map[string]interface{}{"threads": threads}
+}
+
+// core returns the index of the core of a thread, a frame or a variable
+// reference
+func (adapter *adapter) core(current request, field string, divisor int) (int, bool) {
+	var arguments map[string]interface{}
+
+	json.Unmarshal(current.Arguments, &arguments)
+
+	value, ok := arguments[field].(float64)
+	index := (int(value) - 1) / divisor
+
+	if !ok || value < 1 || index >= len(adapter.debugger.cores) {
+		adapter.fail(current, "Unknown "+field)
+		return 0, false
+	}
+
+	return index, true
+}
+
+// stackTrace returns the frame of a core, pointing to its next instruction
+func (adapter *adapter) stackTrace(current request) {
+	index, ok := adapter.core(current, "threadId", 1)
+	if !ok {
+		return
+	}
+
+	debugger := adapter.debugger
+	pc := debugger.cores[index].instance.PC()
+	path := debugger.program.Path()
+
+	frame := map[string]interface{}{
+		"id":                          index + 1,
+		"name":                        debugger.word(pc),
+		"line":                        0,
+		"column":                      0,
+		"instructionPointerReference": strconv.Itoa(pc),
+	}
+
+	if statement, ok := debugger.statement(pc); ok {
+		frame["source"] = source{Name: filepath.Base(path), Path: path}
+		frame["line"] = statement.Line
+		frame["column"] = 1
+	}
+
+	adapter.respond(current, map[string]interface{}{"stackFrames": []interface{}{frame}, "totalFrames": 1})
+}
+
+// scopes returns the registers and the memory seen by a core
+func (adapter *adapter) scopes(current request) {
+	index, ok := adapter.core(current, "frameId", 1)
+	if !ok {
+		return
+	}
+
+	base, size := adapter.debugger.bus.Device(0).Range()
+	reference := index*REFERENCES + 1
+
+	adapter.respond(current, map[string]interface{}{"scopes": []interface{}{
+		map[string]interface{}{"name": "Registers", "variablesReference": reference + REGISTERS - 1, "expensive": false},
+		map[string]interface{}{"name": "Memory", "variablesReference": reference + MEMORY - 1, "indexedVariables": base + size, "expensive": true},
+	}})
+}
+
+// variables returns the registers, the program counter and the flags of a
+// core, or a range of the words of the memory
+func (adapter *adapter) variables(current request) {
+	index, ok := adapter.core(current, "variablesReference", REFERENCES)
+	if !ok {
+		return
+	}
+
+	var arguments struct {
+		VariablesReference int `json:"variablesReference"`
+		Start              int `json:"start"`
+		Count              int `json:"count"`
+	}
+
+	json.Unmarshal(current.Arguments, &arguments)
+
+	debugger := adapter.debugger
+	instance := debugger.cores[index].instance
+	variables := make([]map[string]interface{}, 0)
+
+	variable := func(name string, value string) {
+		variables = append(variables, map[string]interface{}{"name": name, "value": value, "variablesReference": 0})
+	}
+
+	if (arguments.VariablesReference-1)%REFERENCES+1 == REGISTERS {
+		for register, value := range instance.Registers() {
+			variable(debugger.registers[register], strconv.Itoa(value))
+		}
+
+		variable("PC", strconv.Itoa(instance.PC()))
+		variable("flags", fmt.Sprintf("%#x", instance.Flags()))
+	} else {
+		base, size := debugger.bus.Device(0).Range()
+		start, end := arguments.Start, base+size
+
+		// The window is clamped to the memory, a count of 0 reads until
+		// its end
+		if start < base {
+			start = base
+		}
+
+		if arguments.Count > 0 && arguments.Count < end-start {
+			end = start + arguments.Count
+		}
+
+		for address := start; address < end; address++ {
+			variable(strconv.Itoa(address), debugger.word(address))
+		}
+	}
+
+	adapter.respond(current, map[string]interface{}{"variables": variables})
+}
+
+// readMemory reads bytes from the words of the bus the way the GDB stub
+// does, a word spans wordLength/8 bytes in little-endian order
+func (adapter *adapter) readMemory(current request) {
+	var arguments struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+
+	json.Unmarshal(current.Arguments, &arguments)
+
+	start, err := strconv.ParseInt(arguments.MemoryReference, 0, 0)
+	if err != nil {
+		adapter.fail(current, "Invalid memory reference "+arguments.MemoryReference)
+		return
+	}
+
+	debugger := adapter.debugger
+	size := debugger.wordLength / 8
+	address := int(start) + arguments.Offset
+	data := make([]byte, 0)
+
+	for current := address; current < address+arguments.Count; current++ {
+		value, ok := debugger.value(current / size)
+		if !ok || current < 0 {
+			break
+		}
+
+		data = append(data, byte(value>>(8*uint(current%size))))
+	}
+
+	adapter.respond(current, map[string]interface{}{
+		"address":         strconv.Itoa(address),
+		"data":            base64.StdEncoding.EncodeToString(data),
+		"unreadableBytes": arguments.Count - len(data),
+	})
+}
@@ -0,0 +1,472 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+var stateNames = []string{"I", "S", "E", "M"}
+
+const usage = `Commands:
+  break <address>        break on the instruction at an address (b)
+  break label <label>    break on the instruction declaring a label
+  break line <line>      break on the first instruction of a source line
+  watch <address>        stop when an instruction writes a bus address (w)
+  rwatch <address>       stop when an instruction reads a bus address
+  awatch <address>       stop when an instruction reads or writes a bus address
+  watch <reg> <op> <n>   stop when a condition on a register starts to hold,
+                         op is one of == != < <= > >=
+  delete <id>            delete a breakpoint or watchpoint (d)
+  breakpoints            list the breakpoints and watchpoints
+  step [count]           execute instructions of the selected core (s)
+  cycle [count]          run bus cycles
+  continue               run until a breakpoint is hit (c)
+  step-back [count]      undo instructions of the selected core (sb)
+  reverse-continue [addr]
+                         undo instructions until a breakpoint or watchpoint
+                         is hit, or until the previous write of an address (rc)
+  history [count]        print the last instructions and their changes
+  writes <address>       print the recorded writes of a bus address
+  core <id>              select a core
+  registers              print the registers of the selected core (r)
+  flags                  print the flags of the selected core
+  cache [level]          print the lines of a cache: instruction, data or unified
+  memory <address> [n]   print n words from a bus address (x)
+  quit                   stop the machine (q)
+An empty line repeats the last command`
+
+// run executes a command, telling if the machine must resume
+func (debugger *debugger) run(fields []string) bool {
+	command, args := fields[0], fields[1:]
+
+	switch command {
+	case "help", "h":
+		fmt.Println(usage)
+	case "continue", "c":
+		return true
+	case "step", "s":
+		if steps, ok := count(args); ok {
+			debugger.steps = steps
+			return true
+		}
+	case "step-back", "sb":
+		if steps, ok := count(args); ok {
+			debugger.back(debugger.stepBack, steps)
+		}
+	case "reverse-continue", "rc":
+		debugger.reverse(args)
+	case "history":
+		debugger.printHistory(args)
+	case "writes":
+		debugger.printWrites(args)
+	case "cycle":
+		if cycles, ok := count(args); ok {
+			debugger.wake(cycles * debugger.bus.Period())
+			return true
+		}
+	case "break", "b":
+		debugger.add(args)
+	case "watch", "w":
+		debugger.watch(WRITE, args)
+	case "rwatch":
+		debugger.watch(READ, args)
+	case "awatch":
+		debugger.watch(ACCESS, args)
+	case "delete", "d":
+		debugger.delete(args)
+	case "breakpoints":
+		debugger.list()
+	case "core":
+		debugger.selectCore(args)
+	case "registers", "r":
+		debugger.printRegisters()
+	case "flags":
+		debugger.printFlags()
+	case "cache":
+		debugger.printCache(args)
+	case "memory", "x":
+		debugger.printMemory(args)
+	case "quit", "q":
+		debugger.detached = true
+		debugger.kernel.Stop()
+		return true
+	default:
+		fmt.Println("Unknown command:", command, "(try help)")
+	}
+
+	return false
+}
+
+// number parses a decimal or hexadecimal number
+func number(text string) (int, bool) {
+	value, err := strconv.ParseInt(text, 0, 0)
+	if err != nil {
+		fmt.Println("Invalid number:", text)
+		return 0, false
+	}
+
+	return int(value), true
+}
+
+// count parses the optional count of a command, which must be positive
+func count(args []string) (int, bool) {
+	if len(args) == 0 {
+		return 1, true
+	}
+
+	value, ok := number(args[0])
+	if ok && value < 1 {
+		fmt.Println("The count must be positive")
+		return 0, false
+	}
+
+	return value, ok
+}
+
+func (debugger *debugger) add(args []string) {
+	kind := ADDRESS
+
+	if len(args) == 2 {
+		switch args[0] {
+		case "label":
+			kind = LABEL
+		case "line":
+			kind = LINE
+		default:
+			fmt.Println("Unknown breakpoint kind:", args[0])
+			return
+		}
+
+		args = args[1:]
+	}
+
+	if len(args) != 1 {
+		fmt.Println("Usage: break <address> | break label <label> | break line <line>")
+		return
+	}
+
+	value, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	if kind == LINE {
+		if _, ok := debugger.resolve(nil, breakpoint{kind: LINE, value: value}); !ok {
+			fmt.Println("No instructions on line", value)
+			return
+		}
+	}
+
+	current := debugger.insert(breakpoint{kind: kind, value: value})
+
+	fmt.Printf("Breakpoint %d at %s\n", current.id, debugger.describe(current))
+}
+
+// insert numbers a breakpoint or watchpoint and adds it
+func (debugger *debugger) insert(current breakpoint) breakpoint {
+	current.id = debugger.next
+	debugger.next++
+	debugger.breakpoints = append(debugger.breakpoints, current)
+
+	return current
+}
+
+// remove deletes the first breakpoint or watchpoint of a kind on a value
+func (debugger *debugger) remove(kind int, value int) bool {
+	for index, current := range debugger.breakpoints {
+		if current.kind == kind && current.value == value {
+			debugger.breakpoints = append(debugger.breakpoints[:index], debugger.breakpoints[index+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// watch adds a watchpoint on a bus address, or a condition on a register
+// which is evaluated on every core once it is set
+func (debugger *debugger) watch(kind int, args []string) {
+	if len(args) == 3 && kind == WRITE {
+		debugger.condition(args)
+		return
+	}
+
+	if len(args) != 1 {
+		fmt.Println("Usage: watch <address> | watch <register> <operator> <value>")
+		return
+	}
+
+	address, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	current := debugger.insert(breakpoint{kind: kind, value: address})
+
+	fmt.Printf("Watchpoint %d on %s\n", current.id, debugger.describe(current))
+}
+
+func (debugger *debugger) condition(args []string) {
+	register := -1
+	for index, name := range debugger.registers {
+		if strings.EqualFold(name, args[0]) {
+			register = index
+		}
+	}
+
+	if register < 0 {
+		fmt.Println("Unknown register:", args[0])
+		return
+	}
+
+	if _, ok := operators[args[1]]; !ok {
+		fmt.Println("Unknown operator:", args[1])
+		return
+	}
+
+	value, ok := number(args[2])
+	if !ok {
+		return
+	}
+
+	current := breakpoint{kind: CONDITION, register: register, operator: args[1], value: value, held: make(map[cpu.Instance]bool)}
+	for _, core := range debugger.cores {
+		current.held[core.instance] = current.holds(core.instance)
+	}
+
+	current = debugger.insert(current)
+
+	fmt.Printf("Watchpoint %d on %s\n", current.id, debugger.describe(current))
+}
+
+// describe writes what a breakpoint or watchpoint stops on
+func (debugger *debugger) describe(current breakpoint) string {
+	if current.kind == CONDITION {
+		return fmt.Sprintf("%s %s %d", debugger.registers[current.register], current.operator, current.value)
+	}
+
+	return fmt.Sprintf("%s %d", kindNames[current.kind], current.value)
+}
+
+func (debugger *debugger) delete(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: delete <id>")
+		return
+	}
+
+	id, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	for index, current := range debugger.breakpoints {
+		if current.id == id {
+			debugger.breakpoints = append(debugger.breakpoints[:index], debugger.breakpoints[index+1:]...)
+			return
+		}
+	}
+
+	fmt.Println("No breakpoint or watchpoint", id)
+}
+
+func (debugger *debugger) list() {
+	if len(debugger.breakpoints) == 0 {
+		fmt.Println("No breakpoints or watchpoints")
+		return
+	}
+
+	instance := debugger.cores[debugger.current].instance
+
+	for _, current := range debugger.breakpoints {
+		if !isBreakpoint(current.kind) {
+			fmt.Printf("%3d  watch %s\n", current.id, debugger.describe(current))
+			continue
+		}
+
+		where := "not fetched yet"
+
+		if address, ok := debugger.resolve(instance, current); ok {
+			where = "address " + strconv.Itoa(address)
+		}
+
+		fmt.Printf("%3d  break %s (%s)\n", current.id, debugger.describe(current), where)
+	}
+}
+
+func (debugger *debugger) selectCore(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: core <id>")
+		return
+	}
+
+	id, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	if id < 0 || id >= len(debugger.cores) {
+		fmt.Println("No core", id)
+		return
+	}
+
+	debugger.current = id
+	fmt.Print("Selected ")
+	debugger.locate(debugger.cores[id].instance.PC())
+}
+
+func (debugger *debugger) printRegisters() {
+	instance := debugger.cores[debugger.current].instance
+	values := make([]string, 0)
+
+	for index, value := range instance.Registers() {
+		name := strconv.Itoa(index)
+		if index < len(debugger.registers) {
+			name = debugger.registers[index]
+		}
+
+		values = append(values, fmt.Sprintf("%s=%d", name, value))
+	}
+
+	fmt.Printf("%s  PC=%d\n", strings.Join(values, " "), instance.PC())
+}
+
+func (debugger *debugger) printFlags() {
+	flags := debugger.cores[debugger.current].instance.Flags()
+	names := make([]string, 0)
+
+	if flags&cpu.FlagCondition != 0 {
+		names = append(names, "condition")
+	}
+
+	if flags&cpu.FlagInterrupt != 0 {
+		names = append(names, "interrupt")
+	}
+
+	fmt.Printf("flags=%#x [%s]\n", flags, strings.Join(names, " "))
+}
+
+func (debugger *debugger) printCache(args []string) {
+	caches := debugger.cores[debugger.current].caches
+	levels := map[string]cache.Instance{
+		"instruction": caches.Instruction,
+		"data":        caches.Data,
+		"unified":     caches.Unified,
+	}
+
+	name := "data"
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	level, ok := levels[name]
+	if !ok {
+		fmt.Println("Unknown cache:", name)
+		return
+	}
+
+	if level == nil {
+		fmt.Println("The", name, "cache is disabled")
+		return
+	}
+
+	lines := level.Lines()
+	if len(lines) == 0 {
+		fmt.Println("The", name, "cache is empty")
+		return
+	}
+
+	for _, line := range lines {
+		words := make([]string, len(line.Words))
+
+		for offset, word := range line.Words {
+			words[offset] = strconv.Itoa(word)
+
+			if line.Dirty[offset] {
+				words[offset] += "*"
+			}
+		}
+
+		fmt.Printf("set %3d way %d  base %5d  %s  %s\n", line.Set, line.Way, line.Base, stateNames[line.State], strings.Join(words, " "))
+	}
+}
+
+func (debugger *debugger) printMemory(args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Println("Usage: memory <address> [count]")
+		return
+	}
+
+	start, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	words := 1
+	if len(args) == 2 {
+		if words, ok = count(args[1:]); !ok {
+			return
+		}
+	}
+
+	for address := start; address < start+words; address++ {
+		device := debugger.bus.Device(address)
+		if device == nil {
+			fmt.Printf("%6d  unmapped\n", address)
+			continue
+		}
+
+		fmt.Printf("%6d  %-10s %s\n", address, device.Name(), debugger.word(address))
+	}
+}
+
+// word returns the value at a bus address, the instructions are
+// disassembled
+func (debugger *debugger) word(address int) string {
+	device := debugger.bus.Device(address)
+	if device == nil {
+		return "unmapped"
+	}
+
+	base, _ := device.Range()
+	payload := parser.Sorted(device.Read(address - base))
+
+	if len(payload) > 0 && payload[0].Type == parser.CALL {
+		return debugger.format(debugger.decoder.Decode(payload))
+	}
+
+	return strconv.Itoa(debugger.decoder.Value(payload))
+}
+
+// format writes an instruction the way it is written in the source code
+func (debugger *debugger) format(instruction parser.Action) string {
+	text := parser.Mnemonic(instruction.Action)
+
+	if instruction.Location.Type == parser.CALL {
+		return text
+	}
+
+	operands := []string{debugger.operand(instruction.Location)}
+	for _, parameter := range instruction.Parameters {
+		operands = append(operands, debugger.operand(parameter))
+	}
+
+	return text + " " + strings.Join(operands, ", ")
+}
+
+func (debugger *debugger) operand(parameter parser.Parameter) string {
+	switch parameter.Type {
+	case parser.REGISTER:
+		if parameter.Value >= 0 && parameter.Value < len(debugger.registers) {
+			return debugger.registers[parameter.Value]
+		}
+	case parser.MEMORY:
+		return fmt.Sprintf("0x%03x", parameter.Value)
+	}
+
+	return strconv.Itoa(parameter.Value)
+}
@@ -0,0 +1,52 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// console reads the commands of the debugger from the standard input
+type console struct {
+	debugger *debugger
+	scanner  *bufio.Scanner
+	last     string
+}
+
+func newConsole(debugger *debugger) *console {
+	return &console{debugger: debugger, scanner: bufio.NewScanner(os.Stdin)}
+}
+
+// paused prints the stop and reads commands until one of them resumes the
+// machine. The debugger is detached once the input ends
+func (console *console) paused(current stop) {
+	console.debugger.where(current.reason, current.pc)
+
+	for {
+		fmt.Print("(debug) ")
+
+		if !console.scanner.Scan() {
+			fmt.Println("")
+			console.debugger.detached = true
+			return
+		}
+
+		line := strings.TrimSpace(console.scanner.Text())
+		if line == "" {
+			line = console.last
+		}
+
+		if line == "" {
+			continue
+		}
+
+		console.last = line
+
+		if console.debugger.run(strings.Fields(line)) {
+			return
+		}
+	}
+}
+
+func (console *console) close() {}
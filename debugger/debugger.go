@@ -0,0 +1,430 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/io"
+	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/sim"
+)
+
+// This constants are the kinds of breakpoints and watchpoints
+const (
+	// ADDRESS breaks on the instruction at an address
+	ADDRESS = iota
+	// LABEL breaks on the instruction declaring a label
+	LABEL
+	// LINE breaks on the first instruction of a source line
+	LINE
+	// WRITE watches the stores of the instructions to a bus address
+	WRITE
+	// READ watches the operands read by the instructions from a bus
+	// address
+	READ
+	// ACCESS watches both the reads and the writes of a bus address
+	ACCESS
+	// CONDITION watches a register, stopping once a condition on it
+	// starts to hold
+	CONDITION
+)
+
+var kindNames = []string{"address", "label", "line", "write", "read", "access", "condition"}
+
+// operators compare a register with a value in the conditions
+var operators = map[string]func(int, int) bool{
+	"==": func(left int, right int) bool { return left == right },
+	"!=": func(left int, right int) bool { return left != right },
+	"<":  func(left int, right int) bool { return left < right },
+	"<=": func(left int, right int) bool { return left <= right },
+	">":  func(left int, right int) bool { return left > right },
+	">=": func(left int, right int) bool { return left >= right },
+}
+
+// breakpoint is a breakpoint or a watchpoint. The conditions remember if
+// they held on every core after its last instruction
+type breakpoint struct {
+	id       int
+	kind     int
+	value    int
+	register int
+	operator string
+	held     map[cpu.Instance]bool
+}
+
+// isBreakpoint tells if a kind stops before an instruction starts
+func isBreakpoint(kind int) bool {
+	return kind == ADDRESS || kind == LABEL || kind == LINE
+}
+
+// watches tells if a watchpoint stops on a data access
+func (current breakpoint) watches(access cpu.Access) bool {
+	if current.value != access.Address {
+		return false
+	}
+
+	switch current.kind {
+	case WRITE:
+		return access.Write
+	case READ:
+		return !access.Write
+	case ACCESS:
+		return true
+	}
+
+	return false
+}
+
+// holds tells if a condition holds on the registers of a core
+func (current breakpoint) holds(instance cpu.Instance) bool {
+	registers := instance.Registers()
+
+	return operators[current.operator](registers[current.register], current.value)
+}
+
+// This constants are the signals reported to the remote debuggers
+const (
+	SIGINT  = 2
+	SIGTRAP = 5
+)
+
+// stop describes a pause of the machine, pc is the address of the
+// instruction shown. Hit is the breakpoint or watchpoint hit, if any
+type stop struct {
+	reason string
+	pc     int
+	signal int
+	hit    *breakpoint
+	access cpu.Access
+	// midway tells if the instruction was paused before completing
+	midway bool
+	// exhausted tells if the history ended while going back in time
+	exhausted bool
+}
+
+// frontend talks to the user of the debugger while the machine is paused
+type frontend interface {
+	// paused reports a stop and serves the requests until one of them
+	// resumes the machine
+	paused(stop)
+	// close ends the session once the machine stopped
+	close()
+}
+
+// core is a core attached to the debugger along with its caches
+type core struct {
+	instance cpu.Instance
+	caches   cache.Levels
+	// resumed tells if the core was moved back in time, the instruction it
+	// starts again does not stop it
+	resumed bool
+}
+
+type debugger struct {
+	kernel      sim.Instance
+	bus         b.Instance
+	program     io.Instance
+	registers   []string
+	wordLength  int
+	encoder     parser.Encoder
+	decoder     parser.Decoder
+	frontend    frontend
+	cores       []core
+	current     int
+	breakpoints []breakpoint
+	next        int
+	started     bool
+	steps       int
+	alarm       int
+	detached    bool
+	last        stop
+	history     *history
+}
+
+// Instance is the interface of the debugger type. The machine is paused
+// while the debugger reads commands, from inside the event of the core
+// about to start an instruction or from an event of its own
+type Instance interface {
+	cpu.Hook
+	Attach(cpu.Instance, cache.Levels)
+	Close()
+}
+
+// New returns a new instance of the debugger, reading the commands from the
+// standard input. The machine is paused before the first instruction
+func New(kernel sim.Instance, bus b.Instance, program io.Instance, registers []string, word int) Instance {
+	debugger := newDebugger(kernel, bus, program, registers, word)
+	debugger.frontend = newConsole(debugger)
+
+	return debugger
+}
+
+func newDebugger(kernel sim.Instance, bus b.Instance, program io.Instance, registers []string, word int) *debugger {
+	return &debugger{
+		kernel:      kernel,
+		bus:         bus,
+		program:     program,
+		registers:   registers,
+		wordLength:  word,
+		encoder:     parser.NewEncoder(registers, word),
+		decoder:     parser.NewDecoder(word),
+		cores:       make([]core, 0),
+		breakpoints: make([]breakpoint, 0),
+		next:        1,
+		history:     newHistory(),
+	}
+}
+
+// Attach adds a core to the debugger, the cores are selected by the order
+// they were attached
+func (debugger *debugger) Attach(instance cpu.Instance, caches cache.Levels) {
+	debugger.cores = append(debugger.cores, core{instance: instance, caches: caches})
+}
+
+// Starting pauses the machine when the instruction about to start hits a
+// breakpoint or ends a step of the selected core
+func (debugger *debugger) Starting(instance cpu.Instance, pc int) {
+	if debugger.detached {
+		return
+	}
+
+	index := debugger.index(instance)
+	current := stop{pc: pc, signal: SIGTRAP}
+
+	debugger.begin(index, pc)
+
+	if debugger.cores[index].resumed {
+		debugger.cores[index].resumed = false
+		return
+	}
+
+	if hit, ok := debugger.hit(instance, pc); ok {
+		current.reason = "breakpoint " + strconv.Itoa(hit.id)
+		current.hit = &hit
+	} else if !debugger.started {
+		current.reason = "start"
+	} else if debugger.steps > 0 && index == debugger.current {
+		debugger.steps--
+
+		if debugger.steps == 0 {
+			current.reason = "step"
+		}
+	}
+
+	if current.reason == "" {
+		return
+	}
+
+	debugger.current = index
+	debugger.pause(current)
+}
+
+// Accessing pauses the machine when a data access of an instruction hits a
+// watchpoint, the instruction completes once the machine resumes
+func (debugger *debugger) Accessing(instance cpu.Instance, pc int, access cpu.Access) {
+	if debugger.detached {
+		return
+	}
+
+	debugger.accessed(debugger.index(instance), access)
+
+	for _, current := range debugger.breakpoints {
+		if !current.watches(access) {
+			continue
+		}
+
+		reason := fmt.Sprintf("watchpoint %d, read %d", current.id, access.Address)
+		if access.Write {
+			reason = fmt.Sprintf("watchpoint %d, write %d = %d", current.id, access.Address, access.Value)
+		}
+
+		hit := current
+		debugger.current = debugger.index(instance)
+		debugger.pause(stop{reason: reason, pc: pc, signal: SIGTRAP, hit: &hit, access: access, midway: true})
+		return
+	}
+}
+
+// Executed pauses the machine when a condition starts to hold after an
+// instruction of a core
+func (debugger *debugger) Executed(instance cpu.Instance, pc int) {
+	if debugger.detached {
+		return
+	}
+
+	debugger.end(debugger.index(instance))
+
+	for _, current := range debugger.breakpoints {
+		if current.kind != CONDITION {
+			continue
+		}
+
+		held := current.held[instance]
+		holds := current.holds(instance)
+		current.held[instance] = holds
+
+		if holds && !held {
+			value := instance.Registers()[current.register]
+			reason := fmt.Sprintf("watchpoint %d, %s with %s = %d", current.id, debugger.describe(current), debugger.registers[current.register], value)
+
+			hit := current
+			debugger.current = debugger.index(instance)
+			debugger.pause(stop{reason: reason, pc: pc, signal: SIGTRAP, hit: &hit})
+			return
+		}
+	}
+}
+
+func (debugger *debugger) index(instance cpu.Instance) int {
+	for index, current := range debugger.cores {
+		if current.instance == instance {
+			return index
+		}
+	}
+
+	return 0
+}
+
+// hit returns the breakpoint matching an instruction of a core
+func (debugger *debugger) hit(instance cpu.Instance, pc int) (breakpoint, bool) {
+	for _, current := range debugger.breakpoints {
+		if !isBreakpoint(current.kind) {
+			continue
+		}
+
+		if address, ok := debugger.resolve(instance, current); ok && address == pc {
+			return current, true
+		}
+	}
+
+	return breakpoint{}, false
+}
+
+// resolve returns the address of a breakpoint, the labels are only known
+// once they are fetched by the core
+func (debugger *debugger) resolve(instance cpu.Instance, current breakpoint) (int, bool) {
+	switch current.kind {
+	case LABEL:
+		return instance.Label(current.value)
+	case LINE:
+		for _, statement := range debugger.program.Source() {
+			if statement.Line == current.value {
+				return statement.Address, true
+			}
+		}
+
+		return 0, false
+	default:
+		return current.value, true
+	}
+}
+
+// statement returns the source line holding the instruction at an address
+func (debugger *debugger) statement(address int) (io.Statement, bool) {
+	for _, statement := range debugger.program.Source() {
+		if address >= statement.Address && address < statement.Address+statement.Size {
+			return statement, true
+		}
+	}
+
+	return io.Statement{}, false
+}
+
+// pause hands the machine to the frontend until it resumes it. The
+// pending steps and cycles are cancelled, a pause ends them
+func (debugger *debugger) pause(current stop) {
+	debugger.started = true
+	debugger.steps = 0
+	debugger.alarm++
+	debugger.last = current
+
+	debugger.frontend.paused(current)
+}
+
+// Close ends the session of the frontend once the machine stopped
+func (debugger *debugger) Close() {
+	debugger.frontend.close()
+}
+
+// interrupt pauses the machine on request of a remote debugger
+func (debugger *debugger) interrupt() {
+	if !debugger.detached {
+		debugger.pause(stop{reason: "interrupt", pc: debugger.selected().PC(), signal: SIGINT})
+	}
+}
+
+// selected returns the selected core
+func (debugger *debugger) selected() cpu.Instance {
+	return debugger.cores[debugger.current].instance
+}
+
+// wake pauses the machine after the given number of cycles, unless it was
+// paused before
+func (debugger *debugger) wake(cycles int) {
+	alarm := debugger.alarm
+
+	debugger.kernel.Schedule(cycles, func() {
+		if alarm == debugger.alarm && !debugger.detached {
+			debugger.pause(stop{reason: "cycle", pc: debugger.selected().PC(), signal: SIGTRAP})
+		}
+	})
+}
+
+// where prints the reason of a pause and an instruction of the selected
+// core
+func (debugger *debugger) where(reason string, pc int) {
+	fmt.Printf("Paused (%s) at cycle %d, ", reason, debugger.kernel.Cycle())
+	debugger.locate(pc)
+}
+
+// locate prints an instruction of the selected core and its source line
+func (debugger *debugger) locate(pc int) {
+	instance := debugger.selected()
+
+	fmt.Printf("%s at %d: %s\n", instance.Name(), pc, debugger.word(pc))
+
+	if statement, ok := debugger.statement(pc); ok {
+		fmt.Printf("  line %d: %s\n", statement.Line, strings.TrimSpace(statement.Text))
+	}
+}
+
+// value returns the word at a bus address
+func (debugger *debugger) value(address int) (int, bool) {
+	device := debugger.bus.Device(address)
+	if device == nil {
+		return 0, false
+	}
+
+	base, _ := device.Range()
+
+	return debugger.decoder.Value(parser.Sorted(device.Read(address - base))), true
+}
+
+// store writes a word at a bus address. The data caches of the cores
+// holding the address are updated as well without counting the accesses,
+// the instructions already fetched are not
+func (debugger *debugger) store(address int, value int) bool {
+	device := debugger.bus.Device(address)
+	if device == nil {
+		return false
+	}
+
+	base, _ := device.Range()
+	device.Write(address-base, debugger.encoder.MapParams([]string{strconv.Itoa(value)}))
+
+	for _, current := range debugger.cores {
+		for _, level := range []cache.Instance{current.caches.Data, current.caches.Unified} {
+			if level == nil {
+				continue
+			}
+
+			level.Poke(address, value)
+		}
+	}
+
+	return true
+}
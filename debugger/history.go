@@ -0,0 +1,498 @@
+package debugger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+)
+
+// HISTORY is the number of instructions recorded to go back in time, the
+// oldest ones are forgotten
+const HISTORY = 4096
+
+// write is a word written by an instruction, along with the word it
+// replaced as seen by its core
+type write struct {
+	address  int
+	previous int
+	value    int
+}
+
+// change is a line of a cache changed by an instruction, a line missing
+// before or after the instruction is not valid
+type change struct {
+	level  string
+	before *cache.Line
+	after  *cache.Line
+}
+
+// record is an instruction executed by a core, the state of the core
+// before it and the changes it made. The changes of the caches include the
+// refills completed since the previous instruction of the core
+type record struct {
+	id      int
+	core    int
+	pc      int
+	cycle   int
+	before  cpu.State
+	after   cpu.State
+	reads   []int
+	writes  []write
+	changes []change
+}
+
+// history is a ring buffer of the last instructions executed by the cores,
+// in the order they were executed. The instructions being executed are
+// open until they complete
+type history struct {
+	records []record
+	start   int
+	size    int
+	next    int
+	open    map[int]*record
+	lines   map[int]map[string][]cache.Line
+}
+
+func newHistory() *history {
+	return &history{
+		records: make([]record, HISTORY),
+		next:    1,
+		open:    make(map[int]*record),
+		lines:   make(map[int]map[string][]cache.Line),
+	}
+}
+
+// push appends a record, overwriting the oldest one once the buffer is full
+func (history *history) push(current record) {
+	index := (history.start + history.size) % len(history.records)
+	history.records[index] = current
+
+	if history.size < len(history.records) {
+		history.size++
+	} else {
+		history.start = (history.start + 1) % len(history.records)
+	}
+}
+
+// pop removes the last record
+func (history *history) pop() (record, bool) {
+	if history.size == 0 {
+		return record{}, false
+	}
+
+	history.size--
+
+	return history.records[(history.start+history.size)%len(history.records)], true
+}
+
+// at returns a record counted from the oldest one
+func (history *history) at(index int) record {
+	return history.records[(history.start+index)%len(history.records)]
+}
+
+// levelNames are the names of the caches in the history, from the first
+// level to the last one
+var levelNames = []string{"L1I", "L1D", "L2"}
+
+// levels returns the caches of a core in the order of levelNames, the
+// disabled ones are nil
+func levels(caches cache.Levels) []cache.Instance {
+	return []cache.Instance{caches.Instruction, caches.Data, caches.Unified}
+}
+
+// begin opens the record of an instruction about to start, the skipped
+// instructions are never closed
+func (debugger *debugger) begin(index int, pc int) {
+	history := debugger.history
+
+	history.open[index] = &record{
+		core:   index,
+		pc:     pc,
+		before: debugger.cores[index].instance.State(),
+	}
+}
+
+// accessed adds a data access to the record of the instruction, the word
+// replaced by a write is read before the write reaches the caches
+func (debugger *debugger) accessed(index int, access cpu.Access) {
+	current, ok := debugger.history.open[index]
+	if !ok {
+		return
+	}
+
+	if !access.Write {
+		current.reads = append(current.reads, access.Address)
+		return
+	}
+
+	previous, _ := debugger.seen(index, access.Address)
+	current.writes = append(current.writes, write{address: access.Address, previous: previous, value: access.Value})
+}
+
+// end closes the record of an executed instruction, comparing the caches
+// of its core with the ones after its previous instruction
+func (debugger *debugger) end(index int) {
+	history := debugger.history
+
+	current, ok := history.open[index]
+	if !ok {
+		return
+	}
+
+	delete(history.open, index)
+
+	current.cycle = debugger.kernel.Cycle()
+	current.after = debugger.cores[index].instance.State()
+
+	snapshot := make(map[string][]cache.Line)
+	for position, level := range levels(debugger.cores[index].caches) {
+		if level == nil {
+			continue
+		}
+
+		name := levelNames[position]
+		snapshot[name] = level.Lines()
+		current.changes = append(current.changes, compare(name, history.lines[index][name], snapshot[name])...)
+	}
+
+	history.lines[index] = snapshot
+	current.id = history.next
+	history.next++
+	history.push(*current)
+}
+
+// compare returns the lines changed between two copies of a cache
+func compare(level string, before []cache.Line, after []cache.Line) []change {
+	changes := make([]change, 0)
+	previous := make(map[[2]int]*cache.Line)
+	current := make(map[[2]int]bool)
+
+	for index := range before {
+		previous[[2]int{before[index].Set, before[index].Way}] = &before[index]
+	}
+
+	for index := range after {
+		key := [2]int{after[index].Set, after[index].Way}
+		current[key] = true
+
+		if line := previous[key]; line == nil || !same(*line, after[index]) {
+			changes = append(changes, change{level: level, before: line, after: &after[index]})
+		}
+	}
+
+	for index := range before {
+		if !current[[2]int{before[index].Set, before[index].Way}] {
+			changes = append(changes, change{level: level, before: &before[index]})
+		}
+	}
+
+	return changes
+}
+
+func same(left cache.Line, right cache.Line) bool {
+	if left.Base != right.Base || left.State != right.State {
+		return false
+	}
+
+	for offset := range left.Words {
+		if left.Words[offset] != right.Words[offset] || left.Dirty[offset] != right.Dirty[offset] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// seen returns the word at a bus address as seen by a core, from its data
+// caches or from the device
+func (debugger *debugger) seen(index int, address int) (int, bool) {
+	caches := debugger.cores[index].caches
+
+	for _, level := range []cache.Instance{caches.Data, caches.Unified} {
+		if level == nil {
+			continue
+		}
+
+		if value, ok := level.Peek(address); ok {
+			return value, true
+		}
+	}
+
+	return debugger.value(address)
+}
+
+// rewind undoes the last instructions until one of them is the one the
+// given function describes, telling where the machine is now. The words
+// written are stored back and every core undone starts again from its
+// state before its oldest instruction undone, without stopping on it. The
+// lines of the caches are not restored, nor the time. Nothing is undone
+// while an instruction is paused halfway
+func (debugger *debugger) rewind(until func(record) (stop, bool)) (stop, bool) {
+	history := debugger.history
+
+	if debugger.last.midway {
+		return stop{}, false
+	}
+
+	// The instructions started and not executed yet are abandoned
+	for index, current := range history.open {
+		debugger.undo(*current)
+		delete(history.open, index)
+	}
+
+	defer debugger.snapshot()
+
+	for {
+		current, ok := history.pop()
+		if !ok {
+			debugger.last = stop{reason: "no more history", pc: debugger.selected().PC(), signal: SIGTRAP, exhausted: true}
+			return debugger.last, true
+		}
+
+		debugger.undo(current)
+
+		if reached, ok := until(current); ok {
+			debugger.current = current.core
+			reached.pc = current.pc
+			debugger.last = reached
+
+			return reached, true
+		}
+	}
+}
+
+// snapshot copies the caches of every core, the next instruction of a core
+// records the lines changed since
+func (debugger *debugger) snapshot() {
+	for index, current := range debugger.cores {
+		lines := make(map[string][]cache.Line)
+
+		for position, level := range levels(current.caches) {
+			if level != nil {
+				lines[levelNames[position]] = level.Lines()
+			}
+		}
+
+		debugger.history.lines[index] = lines
+	}
+}
+
+// undo restores the words written by an instruction and the state of its
+// core before it
+func (debugger *debugger) undo(current record) {
+	for index := len(current.writes) - 1; index >= 0; index-- {
+		debugger.store(current.writes[index].address, current.writes[index].previous)
+	}
+
+	debugger.cores[current.core].instance.Restore(current.before)
+	debugger.cores[current.core].resumed = true
+}
+
+// stepBack undoes the last instruction of the selected core, along with
+// the instructions of the other cores executed after it
+func (debugger *debugger) stepBack() (stop, bool) {
+	return debugger.rewind(func(current record) (stop, bool) {
+		return stop{reason: "step back", signal: SIGTRAP}, current.core == debugger.current
+	})
+}
+
+// reverseContinue undoes the instructions until one hits a breakpoint or a
+// watchpoint, the conditions are not checked backwards
+func (debugger *debugger) reverseContinue() (stop, bool) {
+	return debugger.rewind(func(current record) (stop, bool) {
+		instance := debugger.cores[current.core].instance
+
+		if hit, ok := debugger.hit(instance, current.pc); ok {
+			return stop{reason: "breakpoint " + strconv.Itoa(hit.id), signal: SIGTRAP, hit: &hit}, true
+		}
+
+		for _, watched := range debugger.breakpoints {
+			for _, written := range current.writes {
+				access := cpu.Access{Address: written.address, Write: true, Value: written.value}
+
+				if watched.watches(access) {
+					reason := fmt.Sprintf("watchpoint %d, write %d = %d", watched.id, written.address, written.value)
+					return stop{reason: reason, signal: SIGTRAP, hit: &watched, access: access}, true
+				}
+			}
+
+			for _, address := range current.reads {
+				access := cpu.Access{Address: address}
+
+				if watched.watches(access) {
+					reason := fmt.Sprintf("watchpoint %d, read %d", watched.id, address)
+					return stop{reason: reason, signal: SIGTRAP, hit: &watched, access: access}, true
+				}
+			}
+		}
+
+		return stop{}, false
+	})
+}
+
+// reverseWrite undoes the instructions until one writes a bus address
+func (debugger *debugger) reverseWrite(address int) (stop, bool) {
+	return debugger.rewind(func(current record) (stop, bool) {
+		for _, written := range current.writes {
+			if written.address == address {
+				reason := fmt.Sprintf("write %d = %d, was %d", address, written.value, written.previous)
+				return stop{reason: reason, signal: SIGTRAP, access: cpu.Access{Address: address, Write: true, Value: written.value}}, true
+			}
+		}
+
+		return stop{}, false
+	})
+}
+
+// describeRecord writes an instruction of the history and its changes
+func (debugger *debugger) describeRecord(current record) []string {
+	instance := debugger.cores[current.core].instance
+	lines := []string{fmt.Sprintf("#%d cycle %d %s at %d: %s", current.id, current.cycle, instance.Name(), current.pc, debugger.word(current.pc))}
+
+	for register, value := range current.after.Registers {
+		if previous := current.before.Registers[register]; previous != value {
+			lines = append(lines, fmt.Sprintf("  %s: %d -> %d", debugger.registers[register], previous, value))
+		}
+	}
+
+	if current.before.Flags != current.after.Flags {
+		lines = append(lines, fmt.Sprintf("  flags: %#x -> %#x", current.before.Flags, current.after.Flags))
+	}
+
+	for _, written := range current.writes {
+		lines = append(lines, fmt.Sprintf("  [%d]: %d -> %d", written.address, written.previous, written.value))
+	}
+
+	for _, changed := range current.changes {
+		lines = append(lines, "  "+describeChange(changed))
+	}
+
+	return lines
+}
+
+func describeChange(changed change) string {
+	line := changed.after
+	if line == nil {
+		line = changed.before
+	}
+
+	text := fmt.Sprintf("%s set %d way %d: ", changed.level, line.Set, line.Way)
+
+	switch {
+	case changed.before == nil:
+		return text + "filled " + describeLine(*changed.after)
+	case changed.after == nil:
+		return text + "invalidated " + describeLine(*changed.before)
+	}
+
+	return text + describeLine(*changed.before) + " -> " + describeLine(*changed.after)
+}
+
+func describeLine(line cache.Line) string {
+	words := make([]string, len(line.Words))
+
+	for offset, word := range line.Words {
+		words[offset] = strconv.Itoa(word)
+
+		if line.Dirty[offset] {
+			words[offset] += "*"
+		}
+	}
+
+	return fmt.Sprintf("base %d %s [%s]", line.Base, stateNames[line.State], strings.Join(words, " "))
+}
+
+// back undoes instructions of the selected core, or the instructions until
+// the previous breakpoint, watchpoint or write of an address
+func (debugger *debugger) back(rewind func() (stop, bool), count int) {
+	var current stop
+
+	for ; count > 0; count-- {
+		reached, ok := rewind()
+		if !ok {
+			fmt.Println("The instruction is halfway, step it before going back")
+			return
+		}
+
+		current = reached
+
+		if current.exhausted {
+			break
+		}
+	}
+
+	debugger.where(current.reason, current.pc)
+}
+
+func (debugger *debugger) reverse(args []string) {
+	if len(args) > 1 {
+		fmt.Println("Usage: reverse-continue [address]")
+		return
+	}
+
+	if len(args) == 0 {
+		debugger.back(debugger.reverseContinue, 1)
+		return
+	}
+
+	address, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	debugger.back(func() (stop, bool) { return debugger.reverseWrite(address) }, 1)
+}
+
+func (debugger *debugger) printHistory(args []string) {
+	history := debugger.history
+
+	count, ok := count(args)
+	if !ok {
+		return
+	}
+
+	if history.size == 0 {
+		fmt.Println("No history")
+		return
+	}
+
+	if count > history.size {
+		count = history.size
+	}
+
+	for index := history.size - count; index < history.size; index++ {
+		fmt.Println(strings.Join(debugger.describeRecord(history.at(index)), "\n"))
+	}
+}
+
+func (debugger *debugger) printWrites(args []string) {
+	if len(args) != 1 {
+		fmt.Println("Usage: writes <address>")
+		return
+	}
+
+	address, ok := number(args[0])
+	if !ok {
+		return
+	}
+
+	found := false
+
+	for index := 0; index < debugger.history.size; index++ {
+		current := debugger.history.at(index)
+
+		for _, written := range current.writes {
+			if written.address == address {
+				found = true
+				fmt.Printf("#%d cycle %d %s at %d: %s  %d -> %d\n", current.id, current.cycle, debugger.cores[current.core].instance.Name(), current.pc, debugger.word(current.pc), written.previous, written.value)
+			}
+		}
+	}
+
+	if !found {
+		fmt.Println("No writes of", address, "in the history")
+	}
+}
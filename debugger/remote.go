@@ -0,0 +1,602 @@
+package debugger
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/io"
+	"github.com/bruunoromero/cpu-emulator/sim"
+)
+
+// remote serves the GDB remote serial protocol over a TCP connection. GDB
+// sees a byte-addressed machine, every word of the bus spans wordLength/8
+// bytes in little-endian order and the program counter is given in bytes
+// as well. The cores are the threads of the target, numbered from 1
+type remote struct {
+	debugger *debugger
+	conn     net.Conn
+	packets  chan string
+	waiting  bool
+}
+
+// Listen returns a new instance of the debugger serving the GDB remote
+// serial protocol on a TCP address. It returns once GDB connects, the
+// machine is paused before the first instruction
+func Listen(address string, kernel sim.Instance, bus b.Instance, program io.Instance, registers []string, word int) (Instance, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	defer listener.Close()
+
+	fmt.Println("Waiting for GDB on", listener.Addr())
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	debugger := newDebugger(kernel, bus, program, registers, word)
+	remote := &remote{debugger: debugger, conn: conn, packets: make(chan string, 16)}
+	debugger.frontend = remote
+
+	go remote.receive()
+
+	return debugger, nil
+}
+
+// receive acknowledges the packets of GDB and queues them to be served
+// while the machine is paused. An interrupt pauses the running machine
+// through the kernel
+func (remote *remote) receive() {
+	defer close(remote.packets)
+
+	reader := bufio.NewReader(remote.conn)
+
+	for {
+		char, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch char {
+		case 0x03:
+			remote.debugger.kernel.Post(remote.debugger.interrupt)
+		case '$':
+			data, err := reader.ReadString('#')
+			if err != nil {
+				return
+			}
+
+			sum := make([]byte, 2)
+			for index := range sum {
+				if sum[index], err = reader.ReadByte(); err != nil {
+					return
+				}
+			}
+
+			packet := data[:len(data)-1]
+			if checksum(packet) != string(sum) {
+				remote.conn.Write([]byte("-"))
+				continue
+			}
+
+			remote.conn.Write([]byte("+"))
+			remote.packets <- unescape(packet)
+		}
+	}
+}
+
+func checksum(packet string) string {
+	sum := 0
+	for index := 0; index < len(packet); index++ {
+		sum += int(packet[index])
+	}
+
+	return fmt.Sprintf("%02x", sum%256)
+}
+
+// unescape restores the bytes escaped in the binary data of a packet
+func unescape(packet string) string {
+	if !strings.Contains(packet, "}") {
+		return packet
+	}
+
+	var builder strings.Builder
+
+	for index := 0; index < len(packet); index++ {
+		if packet[index] == '}' && index+1 < len(packet) {
+			index++
+			builder.WriteByte(packet[index] ^ 0x20)
+			continue
+		}
+
+		builder.WriteByte(packet[index])
+	}
+
+	return builder.String()
+}
+
+func (remote *remote) send(packet string) {
+	fmt.Fprintf(remote.conn, "$%s#%s", packet, checksum(packet))
+}
+
+// paused answers the continue or step request waiting for the stop, then
+// serves the packets until one of them resumes the machine. The debugger is
+// detached once GDB disconnects
+func (remote *remote) paused(current stop) {
+	if remote.waiting {
+		remote.waiting = false
+		remote.send(remote.reply(current))
+	}
+
+	for packet := range remote.packets {
+		if remote.handle(packet) {
+			return
+		}
+	}
+
+	remote.debugger.detached = true
+}
+
+// close tells GDB the machine stopped and ends the connection
+func (remote *remote) close() {
+	remote.send("W00")
+	remote.conn.Close()
+}
+
+// reply returns the stop reply packet of a stop
+func (remote *remote) reply(current stop) string {
+	reply := fmt.Sprintf("T%02xthread:%x;", current.signal, remote.debugger.current+1)
+
+	if current.hit == nil {
+		return reply
+	}
+
+	address := remote.bytes(current.access.Address)
+
+	switch current.hit.kind {
+	case WRITE:
+		reply += fmt.Sprintf("watch:%x;", address)
+	case READ:
+		reply += fmt.Sprintf("rwatch:%x;", address)
+	case ACCESS:
+		reply += fmt.Sprintf("awatch:%x;", address)
+	case ADDRESS, LABEL, LINE:
+		reply += "swbreak:;"
+	}
+
+	return reply
+}
+
+// handle serves a packet, telling if the machine must resume
+func (remote *remote) handle(packet string) bool {
+	if packet == "" {
+		remote.send("")
+		return false
+	}
+
+	debugger := remote.debugger
+
+	switch packet[0] {
+	case '?':
+		remote.send(remote.reply(debugger.last))
+	case 'g':
+		remote.send(remote.registers())
+	case 'G':
+		remote.send(remote.writeRegisters(packet[1:]))
+	case 'p':
+		remote.send(remote.readRegister(packet[1:]))
+	case 'P':
+		remote.send(remote.writeRegister(packet[1:]))
+	case 'm':
+		remote.send(remote.readMemory(packet[1:]))
+	case 'M':
+		remote.send(remote.writeMemory(packet[1:]))
+	case 'Z', 'z':
+		remote.send(remote.point(packet[0] == 'Z', packet[1:]))
+	case 'c':
+		remote.waiting = true
+		return true
+	case 's':
+		debugger.steps = 1
+		remote.waiting = true
+		return true
+	case 'b':
+		remote.send(remote.reverse(packet[1:]))
+	case 'D':
+		remote.send("OK")
+		debugger.detached = true
+		return true
+	case 'k':
+		debugger.detached = true
+		debugger.kernel.Stop()
+		return true
+	case 'H':
+		remote.send(remote.thread(packet[1:]))
+	case 'T':
+		if _, ok := remote.core(packet[1:]); ok {
+			remote.send("OK")
+		} else {
+			remote.send("E01")
+		}
+	case 'q':
+		remote.send(remote.query(packet[1:]))
+	default:
+		remote.send("")
+	}
+
+	return false
+}
+
+// reverse steps or continues backwards through the history, the machine
+// stays paused. The beginning of the history is told to GDB
+func (remote *remote) reverse(arguments string) string {
+	var current stop
+	var ok bool
+
+	switch arguments {
+	case "s":
+		current, ok = remote.debugger.stepBack()
+	case "c":
+		current, ok = remote.debugger.reverseContinue()
+	default:
+		return ""
+	}
+
+	if !ok {
+		return "E01"
+	}
+
+	if current.exhausted {
+		return remote.reply(current) + "replaylog:begin;"
+	}
+
+	return remote.reply(current)
+}
+
+// bytes returns the byte address of a word
+func (remote *remote) bytes(address int) int {
+	return address * (remote.debugger.wordLength / 8)
+}
+
+// encode writes a word as little-endian hexadecimal bytes
+func (remote *remote) encode(value int) string {
+	var builder strings.Builder
+
+	for index := 0; index < remote.debugger.wordLength/8; index++ {
+		fmt.Fprintf(&builder, "%02x", (value>>(8*uint(index)))&0xff)
+	}
+
+	return builder.String()
+}
+
+// decode reads a word written as little-endian hexadecimal bytes
+func (remote *remote) decode(text string) (int, bool) {
+	data, err := hex.DecodeString(text)
+	if err != nil || len(data) != remote.debugger.wordLength/8 {
+		return 0, false
+	}
+
+	value := 0
+	for index := len(data) - 1; index >= 0; index-- {
+		value = value<<8 | int(data[index])
+	}
+
+	return remote.signed(value), true
+}
+
+// signed extends the sign of a word
+func (remote *remote) signed(value int) int {
+	bits := uint(remote.debugger.wordLength)
+
+	if bits < strconv.IntSize {
+		value &= 1<<bits - 1
+
+		if value >= 1<<(bits-1) {
+			value -= 1 << bits
+		}
+	}
+
+	return value
+}
+
+// values returns the registers of the selected core as GDB numbers them,
+// followed by the program counter and the flags
+func (remote *remote) values() []int {
+	instance := remote.debugger.selected()
+
+	return append(instance.Registers(), remote.bytes(instance.PC()), instance.Flags())
+}
+
+func (remote *remote) registers() string {
+	var builder strings.Builder
+
+	for _, value := range remote.values() {
+		builder.WriteString(remote.encode(value))
+	}
+
+	return builder.String()
+}
+
+func (remote *remote) readRegister(arguments string) string {
+	index, err := strconv.ParseInt(arguments, 16, 0)
+	values := remote.values()
+
+	if err != nil || index < 0 || int(index) >= len(values) {
+		return "E01"
+	}
+
+	return remote.encode(values[index])
+}
+
+// writeRegister writes a register, the ID register, the program counter
+// and the flags are read-only
+func (remote *remote) writeRegister(arguments string) string {
+	parts := strings.SplitN(arguments, "=", 2)
+	if len(parts) != 2 {
+		return "E01"
+	}
+
+	index, err := strconv.ParseInt(parts[0], 16, 0)
+	value, ok := remote.decode(parts[1])
+
+	if err != nil || !ok || !remote.debugger.selected().SetRegister(int(index), value) {
+		return "E01"
+	}
+
+	return "OK"
+}
+
+// writeRegisters writes every writable register, the values of the
+// read-only ones are ignored
+func (remote *remote) writeRegisters(arguments string) string {
+	size := remote.debugger.wordLength / 4
+	values := remote.values()
+
+	if len(arguments) != size*len(values) {
+		return "E01"
+	}
+
+	instance := remote.debugger.selected()
+
+	for index := range instance.Registers() {
+		value, ok := remote.decode(arguments[index*size : (index+1)*size])
+		if !ok {
+			return "E01"
+		}
+
+		instance.SetRegister(index, value)
+	}
+
+	return "OK"
+}
+
+// span parses the address and the length of a memory or point packet
+func span(arguments string) (int, int, bool) {
+	parts := strings.SplitN(arguments, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	address, addressErr := strconv.ParseInt(parts[0], 16, 0)
+	length, lengthErr := strconv.ParseInt(parts[1], 16, 0)
+
+	if addressErr != nil || lengthErr != nil || address < 0 || length < 0 {
+		return 0, 0, false
+	}
+
+	return int(address), int(length), true
+}
+
+// readMemory reads bytes from the words of the bus, stopping at the first
+// address not mapped
+func (remote *remote) readMemory(arguments string) string {
+	address, length, ok := span(arguments)
+	if !ok {
+		return "E01"
+	}
+
+	size := remote.debugger.wordLength / 8
+	var builder strings.Builder
+
+	for current := address; current < address+length; current++ {
+		value, ok := remote.debugger.value(current / size)
+		if !ok {
+			break
+		}
+
+		fmt.Fprintf(&builder, "%02x", (value>>(8*uint(current%size)))&0xff)
+	}
+
+	if builder.Len() == 0 && length > 0 {
+		return "E14"
+	}
+
+	return builder.String()
+}
+
+// writeMemory writes bytes into the words of the bus, word by word
+func (remote *remote) writeMemory(arguments string) string {
+	parts := strings.SplitN(arguments, ":", 2)
+	if len(parts) != 2 {
+		return "E01"
+	}
+
+	address, length, ok := span(parts[0])
+	data, err := hex.DecodeString(parts[1])
+
+	if !ok || err != nil || len(data) != length {
+		return "E01"
+	}
+
+	size := remote.debugger.wordLength / 8
+
+	for offset := 0; offset < length; {
+		word := (address + offset) / size
+
+		value, ok := remote.debugger.value(word)
+		if !ok {
+			return "E14"
+		}
+
+		for ; offset < length && (address+offset)/size == word; offset++ {
+			shift := 8 * uint((address+offset)%size)
+			value = value&^(0xff<<shift) | int(data[offset])<<shift
+		}
+
+		remote.debugger.store(word, remote.signed(value))
+	}
+
+	return "OK"
+}
+
+// point inserts or removes a breakpoint or a watchpoint. The software and
+// hardware breakpoints are the same, the watchpoints cover every word of
+// the watched bytes
+func (remote *remote) point(insert bool, arguments string) string {
+	kinds := map[byte]int{'0': ADDRESS, '1': ADDRESS, '2': WRITE, '3': READ, '4': ACCESS}
+
+	if len(arguments) < 2 || arguments[1] != ',' {
+		return ""
+	}
+
+	kind, ok := kinds[arguments[0]]
+	if !ok {
+		return ""
+	}
+
+	address, length, ok := span(arguments[2:])
+	if !ok {
+		return "E01"
+	}
+
+	size := remote.debugger.wordLength / 8
+	first, last := address/size, address/size
+
+	if kind != ADDRESS && length > 0 {
+		last = (address + length - 1) / size
+	}
+
+	for word := first; word <= last; word++ {
+		if insert {
+			remote.debugger.insert(breakpoint{kind: kind, value: word})
+		} else {
+			remote.debugger.remove(kind, word)
+		}
+	}
+
+	return "OK"
+}
+
+// core returns the index of the core of a thread identifier
+func (remote *remote) core(thread string) (int, bool) {
+	id, err := strconv.ParseInt(thread, 16, 0)
+	if err != nil || id < 1 || int(id) > len(remote.debugger.cores) {
+		return 0, false
+	}
+
+	return int(id) - 1, true
+}
+
+// thread selects the core of the register and memory packets, the steps
+// apply to it as well
+func (remote *remote) thread(arguments string) string {
+	if arguments == "" {
+		return "E01"
+	}
+
+	if arguments[1:] == "0" || arguments[1:] == "-1" {
+		return "OK"
+	}
+
+	index, ok := remote.core(arguments[1:])
+	if !ok {
+		return "E01"
+	}
+
+	remote.debugger.current = index
+
+	return "OK"
+}
+
+func (remote *remote) query(query string) string {
+	switch {
+	case strings.HasPrefix(query, "Supported"):
+		return "PacketSize=1000;qXfer:features:read+;swbreak+;hwbreak+;ReverseStep+;ReverseContinue+"
+	case strings.HasPrefix(query, "Xfer:features:read:target.xml:"):
+		return remote.transfer(remote.target(), strings.TrimPrefix(query, "Xfer:features:read:target.xml:"))
+	case query == "fThreadInfo":
+		threads := make([]string, len(remote.debugger.cores))
+		for index := range threads {
+			threads[index] = strconv.FormatInt(int64(index+1), 16)
+		}
+
+		return "m" + strings.Join(threads, ",")
+	case query == "sThreadInfo":
+		return "l"
+	case query == "C":
+		return "QC" + strconv.FormatInt(int64(remote.debugger.current+1), 16)
+	case query == "Attached":
+		return "1"
+	case strings.HasPrefix(query, "ThreadExtraInfo,"):
+		if index, ok := remote.core(strings.TrimPrefix(query, "ThreadExtraInfo,")); ok {
+			return hex.EncodeToString([]byte(remote.debugger.cores[index].instance.Name()))
+		}
+
+		return "E01"
+	}
+
+	return ""
+}
+
+// transfer returns the part of a document asked by a qXfer packet
+func (remote *remote) transfer(document string, arguments string) string {
+	offset, length, ok := span(arguments)
+	if !ok {
+		return "E01"
+	}
+
+	if offset >= len(document) {
+		return "l"
+	}
+
+	end := offset + length
+	if end >= len(document) {
+		return "l" + document[offset:]
+	}
+
+	return "m" + document[offset:end]
+}
+
+// target returns the target description, the registers are followed by
+// the program counter and the flags, all of them as wide as a word
+func (remote *remote) target() string {
+	bits := remote.debugger.wordLength
+	registers := remote.debugger.registers
+
+	var builder strings.Builder
+
+	builder.WriteString("<?xml version=\"1.0\"?>\n")
+	builder.WriteString("<!DOCTYPE target SYSTEM \"gdb-target.dtd\">\n")
+	builder.WriteString("<target version=\"1.0\">\n")
+	builder.WriteString("  <feature name=\"org.cpu-emulator.core\">\n")
+
+	for index, name := range registers {
+		fmt.Fprintf(&builder, "    <reg name=\"%s\" bitsize=\"%d\" type=\"int\" regnum=\"%d\"/>\n", name, bits, index)
+	}
+
+	fmt.Fprintf(&builder, "    <reg name=\"pc\" bitsize=\"%d\" type=\"code_ptr\" regnum=\"%d\"/>\n", bits, len(registers))
+	fmt.Fprintf(&builder, "    <reg name=\"flags\" bitsize=\"%d\" type=\"int\" regnum=\"%d\"/>\n", bits, len(registers)+1)
+
+	builder.WriteString("  </feature>\n")
+	builder.WriteString("</target>\n")
+
+	return builder.String()
+}
@@ -0,0 +1,276 @@
+package debugger
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"testing"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/io"
+	"github.com/bruunoromero/cpu-emulator/memory"
+	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/sim"
+	"github.com/bruunoromero/cpu-emulator/timing"
+)
+
+// serve returns a stub serving a core of 16 bits words and a memory of 8
+// words, GDB talks to it through the returned reader and connection
+func serve(t *testing.T) (*remote, *bufio.Reader, net.Conn) {
+	t.Helper()
+
+	registers := []string{"A", "B", "C", "D", "E"}
+	kernel := sim.New(1)
+	bus := b.New(kernel, 16, 1, b.NewArbiter(b.FIFO))
+	encoder := parser.NewEncoder(registers, 16)
+
+	bus.Attach(memory.New(64, 16, 1, []string{"cpu0"}))
+
+	core := cpu.New(cpu.Config{Registers: len(registers), Word: 16, Memory: 8, Costs: timing.Default()}, interrupt.New(64, 16, 1, encoder), encoder)
+	debugger := newDebugger(kernel, bus, io.New(encoder), registers, 16)
+	debugger.Attach(core, cache.Levels{})
+
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	remote := &remote{debugger: debugger, conn: server, packets: make(chan string, 16)}
+	debugger.frontend = remote
+
+	return remote, bufio.NewReader(client), client
+}
+
+// exchange serves a packet and returns the reply, checking its checksum
+func exchange(t *testing.T, remote *remote, reader *bufio.Reader, packet string) string {
+	t.Helper()
+
+	go remote.handle(packet)
+
+	if start, err := reader.ReadByte(); err != nil || start != '$' {
+		t.Fatalf("%s: the reply does not start a packet", packet)
+	}
+
+	data, err := reader.ReadString('#')
+	if err != nil {
+		t.Fatalf("%s: the reply does not end: %v", packet, err)
+	}
+
+	sum := make([]byte, 2)
+	for index := range sum {
+		if sum[index], err = reader.ReadByte(); err != nil {
+			t.Fatalf("%s: the reply has no checksum: %v", packet, err)
+		}
+	}
+
+	reply := data[:len(data)-1]
+	if checksum(reply) != string(sum) {
+		t.Errorf("%s: reply %q has the checksum %s, want %s", packet, reply, sum, checksum(reply))
+	}
+
+	return reply
+}
+
+// expectReplies serves the packets in order and checks their replies
+func expectReplies(t *testing.T, remote *remote, reader *bufio.Reader, exchanges [][2]string) {
+	t.Helper()
+
+	for _, current := range exchanges {
+		if reply := exchange(t, remote, reader, current[0]); reply != current[1] {
+			t.Errorf("%s: replied %q, want %q", current[0], reply, current[1])
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	tests := map[string]string{
+		"":                                  "00",
+		"OK":                                "9a",
+		"g":                                 "67",
+		"m0,4":                              "fd",
+		"Z0,4,1":                            "47",
+		"qSupported:multiprocess+;swbreak+": "1b",
+	}
+
+	for packet, want := range tests {
+		if got := checksum(packet); got != want {
+			t.Errorf("checksum(%q) = %s, want %s", packet, got, want)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := map[string]string{
+		"M0,2:0102":    "M0,2:0102",
+		"X0,1:}\x03":   "X0,1:#",
+		"X0,2:}\x04}]": "X0,2:$}",
+		"X0,1:}\x0a":   "X0,1:*",
+		"X0,1:}":       "X0,1:}",
+	}
+
+	for packet, want := range tests {
+		if got := unescape(packet); got != want {
+			t.Errorf("unescape(%q) = %q, want %q", packet, got, want)
+		}
+	}
+}
+
+// TestReceive acknowledges the packets with a valid checksum and queues
+// them unescaped, the others are refused
+func TestReceive(t *testing.T) {
+	remote, reader, client := serve(t)
+	go remote.receive()
+
+	tests := []struct {
+		data   string
+		ack    byte
+		packet string
+	}{
+		{"$g#67", '+', "g"},
+		{"$g#00", '-', ""},
+		{"$m0,4#fd", '+', "m0,4"},
+		{"$X0,1:}\x03#" + checksum("X0,1:}\x03"), '+', "X0,1:#"},
+	}
+
+	for _, test := range tests {
+		go client.Write([]byte(test.data))
+
+		ack, err := reader.ReadByte()
+		if err != nil || ack != test.ack {
+			t.Fatalf("%q: acknowledged %q, want %q", test.data, ack, test.ack)
+		}
+
+		if test.ack == '+' {
+			if packet := <-remote.packets; packet != test.packet {
+				t.Errorf("%q: queued %q, want %q", test.data, packet, test.packet)
+			}
+		}
+	}
+}
+
+func TestSpan(t *testing.T) {
+	tests := []struct {
+		arguments string
+		address   int
+		length    int
+		ok        bool
+	}{
+		{"10,4", 16, 4, true},
+		{"0,0", 0, 0, true},
+		{"ff,1", 255, 1, true},
+		{"10", 0, 0, false},
+		{"x,4", 0, 0, false},
+		{"10,y", 0, 0, false},
+		{"-1,2", 0, 0, false},
+		{"2,-2", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		address, length, ok := span(test.arguments)
+		if ok != test.ok || ok && (address != test.address || length != test.length) {
+			t.Errorf("span(%q) = %d, %d, %v, want %d, %d, %v", test.arguments, address, length, ok, test.address, test.length, test.ok)
+		}
+	}
+}
+
+// TestRegisters reads and writes the registers, followed by the program
+// counter and the flags. The last register holds the id of the core
+func TestRegisters(t *testing.T) {
+	remote, reader, _ := serve(t)
+
+	expectReplies(t, remote, reader, [][2]string{
+		{"g", "0000000000000000000000000000"},
+		{"P0=0500", "OK"},
+		{"P1=feff", "OK"},
+		{"p0", "0500"},
+		{"p1", "feff"},
+		{"g", "0500feff00000000000000000000"},
+		{"G0100020003000400090007000800", "OK"},
+		{"g", "0100020003000400000000000000"},
+		{"P4=0100", "E01"},
+		{"P7=0000", "E01"},
+		{"P0=05", "E01"},
+		{"P0", "E01"},
+		{"p9", "E01"},
+		{"pz", "E01"},
+		{"G0100", "E01"},
+		{"G0100020003zz0400090007000800", "E01"},
+	})
+
+	if registers := remote.debugger.selected().Registers(); registers[0] != 1 || registers[3] != 4 {
+		t.Errorf("the core holds the registers %v", registers)
+	}
+}
+
+// TestMemory reads and writes the bytes of the words, in little-endian
+// order
+func TestMemory(t *testing.T) {
+	remote, reader, _ := serve(t)
+
+	expectReplies(t, remote, reader, [][2]string{
+		{"m0,4", "00000000"},
+		{"M2,4:01020304", "OK"},
+		{"m2,4", "01020304"},
+		{"m0,6", "000001020304"},
+		{"m1,2", "0001"},
+		{"M5,1:ff", "OK"},
+		{"m4,2", "03ff"},
+		{"mc,8", "00000000"},
+		{"m10,2", "E14"},
+		{"M10,2:0102", "E14"},
+		{"M0,2:01", "E01"},
+		{"M0,1:zz", "E01"},
+		{"M0,1", "E01"},
+		{"mzz,2", "E01"},
+		{"m0", "E01"},
+	})
+
+	if value, _ := remote.debugger.value(1); value != 0x0201 {
+		t.Errorf("the word 1 holds %#x, want 0x201", value)
+	}
+}
+
+// TestPoints inserts and removes the breakpoints and the watchpoints, the
+// watchpoints cover every word of the bytes watched
+func TestPoints(t *testing.T) {
+	remote, reader, _ := serve(t)
+
+	kinds := func() [][2]int {
+		points := make([][2]int, 0)
+		for _, current := range remote.debugger.breakpoints {
+			points = append(points, [2]int{current.kind, current.value})
+		}
+
+		return points
+	}
+
+	steps := []struct {
+		packet string
+		reply  string
+		points [][2]int
+	}{
+		{"Z0,4,1", "OK", [][2]int{{ADDRESS, 2}}},
+		{"Z1,6,1", "OK", [][2]int{{ADDRESS, 2}, {ADDRESS, 3}}},
+		{"Z2,3,2", "OK", [][2]int{{ADDRESS, 2}, {ADDRESS, 3}, {WRITE, 1}, {WRITE, 2}}},
+		{"Z3,8,2", "OK", [][2]int{{ADDRESS, 2}, {ADDRESS, 3}, {WRITE, 1}, {WRITE, 2}, {READ, 4}}},
+		{"z0,4,1", "OK", [][2]int{{ADDRESS, 3}, {WRITE, 1}, {WRITE, 2}, {READ, 4}}},
+		{"z2,3,2", "OK", [][2]int{{ADDRESS, 3}, {READ, 4}}},
+		{"Z5,0,1", "", [][2]int{{ADDRESS, 3}, {READ, 4}}},
+		{"Z0,x,1", "E01", [][2]int{{ADDRESS, 3}, {READ, 4}}},
+		{"Z0", "", [][2]int{{ADDRESS, 3}, {READ, 4}}},
+	}
+
+	for _, step := range steps {
+		if reply := exchange(t, remote, reader, step.packet); reply != step.reply {
+			t.Errorf("%s: replied %q, want %q", step.packet, reply, step.reply)
+		}
+
+		if points := kinds(); !reflect.DeepEqual(points, step.points) {
+			t.Errorf("%s: points %v, want %v", step.packet, points, step.points)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package disk
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"sync"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/utils"
+)
+
+// SECTORSIZE is the number of words of a sector
+const SECTORSIZE = 8
+
+// SECTORS is the number of sectors of the disk
+const SECTORS = 256
+
+// This constants are the offsets of the disk registers
+const (
+	// SECTOR is the sector of the next command
+	SECTOR = iota
+	// ADDRESS is the data address of the first word transferred
+	ADDRESS
+	// COMMAND starts a transfer when written
+	COMMAND
+	// STATUS holds the state of the disk
+	STATUS
+)
+
+// This constants are the commands of the disk
+const (
+	// NONE is the command of an idle disk
+	NONE = iota
+	// READ copies a sector into the memory
+	READ
+	// WRITE copies the memory into a sector
+	WRITE
+)
+
+// This constants are the values of the status register
+const (
+	IDLE = iota
+	BUSY
+	ERROR
+)
+
+// Instance is the interface of the disk, its registers are mapped on the
+// bus
+type Instance interface {
+	b.Device
+	Snapshot() (Snapshot, error)
+	LoadSnapshot(b.Instance, Snapshot) error
+	Close() error
+}
+
+// Snapshot is the state of the disk along with the contents of its image,
+// which is empty when the image was never written
+type Snapshot struct {
+	Sector    int
+	Address   int
+	Command   int
+	Status    int
+	Key       int
+	Remaining int
+	Issued    bool
+	Buffer    [][]parser.Msg
+	Image     []byte
+	Port      b.PortSnapshot
+}
+
+type disk struct {
+	base       int
+	latency    int
+	line       int
+	offset     int
+	wordLength int
+	sector     int
+	address    int
+	command    int
+	status     int
+	key        int
+	remaining  int
+	issued     bool
+	path       string
+	file       *os.File
+	port       *b.Port
+	buffer     [][]parser.Msg
+	mutex      sync.Mutex
+	encoder    parser.Encoder
+	decoder    parser.Decoder
+	interrupts interrupt.Instance
+}
+
+// New returns a new instance of the disk backed by the image at path.
+// Data addresses are translated into bus addresses with the same offset
+// used by the cpu. The interrupt line is raised at the end of every command
+func New(base int, line int, offset int, path string, wordLength int, latency int, encoder parser.Encoder, interrupts interrupt.Instance) Instance {
+	return &disk{
+		base:       base,
+		latency:    latency,
+		line:       line,
+		offset:     offset,
+		path:       path,
+		wordLength: wordLength,
+		status:     IDLE,
+		command:    NONE,
+		encoder:    encoder,
+		interrupts: interrupts,
+		buffer:     make([][]parser.Msg, SECTORSIZE),
+		decoder:    parser.NewDecoder(wordLength),
+	}
+}
+
+func (disk *disk) Name() string {
+	return "disk"
+}
+
+func (disk *disk) Range() (int, int) {
+	return disk.base, STATUS + 1
+}
+
+// Run starts the DMA engine, which moves the sectors as a bus master
+func (disk *disk) Run(bus b.Instance) {
+	disk.port = b.NewPort(disk, disk.decoder, disk.latency)
+
+	bus.Kernel().Every(1, func() {
+		disk.cycle(bus, disk.port)
+	})
+}
+
+// cycle serves the accesses to the registers and handles the replies to
+// the transactions of the DMA engine
+func (disk *disk) cycle(bus b.Instance, port *b.Port) {
+	for _, message := range port.Receive(bus) {
+		if message[0].Signal == b.REPLY {
+			disk.mutex.Lock()
+			disk.receive(message)
+			disk.mutex.Unlock()
+		} else {
+			port.Serve(bus, message)
+		}
+	}
+
+	disk.mutex.Lock()
+	disk.transfer(bus)
+	disk.mutex.Unlock()
+}
+
+func (disk *disk) Read(position int) []parser.Msg {
+	disk.mutex.Lock()
+	defer disk.mutex.Unlock()
+
+	return disk.encoder.MapParams([]string{strconv.Itoa(*disk.register(position))})
+}
+
+func (disk *disk) Write(position int, payload []parser.Msg) {
+	disk.mutex.Lock()
+	defer disk.mutex.Unlock()
+
+	if disk.status == BUSY {
+		return
+	}
+
+	*disk.register(position) = disk.decoder.Value(payload)
+
+	if position == COMMAND && disk.command != NONE {
+		disk.status = BUSY
+		disk.issued = false
+	}
+}
+
+func (disk *disk) register(position int) *int {
+	switch position {
+	case SECTOR:
+		return &disk.sector
+	case ADDRESS:
+		return &disk.address
+	case COMMAND:
+		return &disk.command
+	default:
+		return &disk.status
+	}
+}
+
+// transfer issues every bus transaction of a new command at once, so they
+// compete with the cpu ones for the bus width
+func (disk *disk) transfer(bus b.Instance) {
+	if disk.status != BUSY || disk.issued {
+		return
+	}
+
+	if !disk.valid(bus) {
+		disk.finish(ERROR)
+		return
+	}
+
+	disk.issued = true
+	disk.remaining = SECTORSIZE
+	disk.key += SECTORSIZE
+
+	if disk.command == READ {
+		if !disk.readSector() {
+			disk.finish(ERROR)
+			return
+		}
+
+		for index, word := range disk.buffer {
+			position := disk.physical(index)
+			bus.Route(disk.Name(), b.WRITE, position, disk.encoder.Transaction(disk.key+index, position, word))
+		}
+	} else {
+		for index := range disk.buffer {
+			position := disk.physical(index)
+			bus.Route(disk.Name(), b.READ, position, disk.encoder.Transaction(disk.key+index, position, nil))
+		}
+	}
+}
+
+// receive handles the acknowledgements of the memory writes of a READ and
+// the words read from the memory by a WRITE
+func (disk *disk) receive(message []parser.Msg) {
+	index := message[0].Key - disk.key
+
+	if !disk.issued || index < 0 || index >= SECTORSIZE {
+		return
+	}
+
+	if disk.command == WRITE {
+		disk.buffer[index] = parser.Sorted(message)
+	}
+
+	disk.remaining--
+
+	if disk.remaining > 0 {
+		return
+	}
+
+	if disk.command == WRITE && !disk.writeSector() {
+		disk.finish(ERROR)
+		return
+	}
+
+	disk.finish(IDLE)
+}
+
+func (disk *disk) finish(status int) {
+	disk.status = status
+	disk.command = NONE
+	disk.issued = false
+	disk.interrupts.Raise(disk.line)
+}
+
+func (disk *disk) physical(index int) int {
+	position := disk.address + index
+
+	if position >= b.IOBASE {
+		return position
+	}
+
+	return position + disk.offset
+}
+
+func (disk *disk) valid(bus b.Instance) bool {
+	if disk.sector < 0 || disk.sector >= SECTORS {
+		return false
+	}
+
+	for index := range disk.buffer {
+		if bus.Device(disk.physical(index)) == nil || disk.physical(index) >= b.IOBASE {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (disk *disk) word(value int, msgType int) []parser.Msg {
+	msgs := make([]parser.Msg, 0)
+
+	for _, value := range utils.ToBytes(disk.wordLength, value) {
+		msgs = append(msgs, parser.Msg{Type: msgType, Value: value})
+	}
+
+	return msgs
+}
+
+func (disk *disk) open() bool {
+	if disk.file != nil {
+		return true
+	}
+
+	file, err := os.OpenFile(disk.path, os.O_RDWR|os.O_CREATE, 0644)
+
+	if err != nil {
+		return false
+	}
+
+	disk.file = file
+	return true
+}
+
+// Close closes the image, it is opened again by the next transfer
+func (disk *disk) Close() error {
+	disk.mutex.Lock()
+	defer disk.mutex.Unlock()
+
+	if disk.file == nil {
+		return nil
+	}
+
+	err := disk.file.Close()
+	disk.file = nil
+
+	return err
+}
+
+func (disk *disk) sectorOffset() int64 {
+	return int64(disk.sector * SECTORSIZE * disk.wordLength / 8)
+}
+
+// readSector loads a sector into the buffer, the words past the end of the
+// image are read as zeros
+func (disk *disk) readSector() bool {
+	if !disk.open() {
+		return false
+	}
+
+	size := disk.wordLength / 8
+	bytes := make([]byte, SECTORSIZE*size)
+	zero := utils.ToBytes(disk.wordLength, 0)
+
+	n, err := disk.file.ReadAt(bytes, disk.sectorOffset())
+
+	if err != nil && err != io.EOF {
+		return false
+	}
+
+	for i := n / size * size; i < len(bytes); i++ {
+		bytes[i] = zero[i%size]
+	}
+
+	for index := range disk.buffer {
+		disk.buffer[index] = disk.word(0, parser.LITERAL)
+
+		for i := range disk.buffer[index] {
+			disk.buffer[index][i].Value = bytes[index*size+i]
+		}
+	}
+
+	return true
+}
+
+func (disk *disk) writeSector() bool {
+	if !disk.open() {
+		return false
+	}
+
+	bytes := make([]byte, 0)
+
+	for _, word := range disk.buffer {
+		for _, msg := range word {
+			bytes = append(bytes, msg.Value)
+		}
+	}
+
+	_, err := disk.file.WriteAt(bytes, disk.sectorOffset())
+
+	return err == nil
+}
+
+// Snapshot returns the state of the disk, reading its image
+func (disk *disk) Snapshot() (Snapshot, error) {
+	disk.mutex.Lock()
+	defer disk.mutex.Unlock()
+
+	image, err := os.ReadFile(disk.path)
+	if err != nil && !os.IsNotExist(err) {
+		return Snapshot{}, err
+	}
+
+	buffer := make([][]parser.Msg, len(disk.buffer))
+	for index, word := range disk.buffer {
+		buffer[index] = append([]parser.Msg(nil), word...)
+	}
+
+	return Snapshot{
+		Sector:    disk.sector,
+		Address:   disk.address,
+		Command:   disk.command,
+		Status:    disk.status,
+		Key:       disk.key,
+		Remaining: disk.remaining,
+		Issued:    disk.issued,
+		Buffer:    buffer,
+		Image:     image,
+		Port:      disk.port.Snapshot(),
+	}, nil
+}
+
+// LoadSnapshot moves the disk to a saved state once it runs on the bus,
+// its image is replaced by the saved one
+func (disk *disk) LoadSnapshot(bus b.Instance, snapshot Snapshot) error {
+	disk.mutex.Lock()
+	defer disk.mutex.Unlock()
+
+	if disk.file != nil {
+		disk.file.Close()
+		disk.file = nil
+	}
+
+	if err := os.WriteFile(disk.path, snapshot.Image, 0644); err != nil {
+		return err
+	}
+
+	disk.sector = snapshot.Sector
+	disk.address = snapshot.Address
+	disk.command = snapshot.Command
+	disk.status = snapshot.Status
+	disk.key = snapshot.Key
+	disk.remaining = snapshot.Remaining
+	disk.issued = snapshot.Issued
+
+	for index := range disk.buffer {
+		disk.buffer[index] = nil
+		if index < len(snapshot.Buffer) {
+			disk.buffer[index] = append([]parser.Msg(nil), snapshot.Buffer[index]...)
+		}
+	}
+
+	disk.port.LoadSnapshot(bus, snapshot.Port)
+
+	return nil
+}
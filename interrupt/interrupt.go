@@ -0,0 +1,228 @@
+package interrupt
+
+import (
+	"strconv"
+	"sync"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// This constants describes the layout of the vector table. The first
+// vectors belong to the synchronous exceptions raised by the cpu and the
+// remaining ones to the hardware lines, vector IRQ+n serving line n.
+// Writing the label of a handler to an entry installs it and writing a
+// negative label removes it, the entries without handler are read as -1
+const (
+	// ILLEGAL is raised by unknown actions, wrong parameters or registers
+	ILLEGAL = iota
+	// DIVIDE is raised by a division by zero
+	DIVIDE
+	// FAULT is raised by an access to an unmapped address or undefined label
+	FAULT
+	// RESERVED is not used yet
+	RESERVED
+	// IRQ is the vector of the first hardware line
+	IRQ
+)
+
+// VECTORS is the number of entries of the vector table
+const VECTORS = 16
+
+// LINES is the number of hardware lines, line 0 having the highest priority
+const LINES = VECTORS - IRQ
+
+// MASK is the offset of the register that masks the hardware lines, one
+// bit per line
+const MASK = VECTORS
+
+type controller struct {
+	base      int
+	latency   int
+	mask      int
+	pending   int
+	vectors   []int
+	installed []bool
+	inService []int
+	port      *b.Port
+	mutex     sync.Mutex
+	encoder   parser.Encoder
+	decoder   parser.Decoder
+}
+
+// Instance is the interface of the interrupt controller. The vector table
+// and the mask register are mapped on the bus
+type Instance interface {
+	b.Device
+	Raise(int)
+	Pending() (int, bool)
+	Acknowledge(int)
+	Complete()
+	Vector(int) (int, bool)
+	Snapshot() Snapshot
+	LoadSnapshot(b.Instance, Snapshot)
+}
+
+// Snapshot is the state of the interrupt controller
+type Snapshot struct {
+	Mask      int
+	Pending   int
+	Vectors   []int
+	Installed []bool
+	InService []int
+	Port      b.PortSnapshot
+}
+
+// New returns a new instance of the interrupt controller
+func New(base int, wordLength int, latency int, encoder parser.Encoder) Instance {
+	return &controller{
+		base:      base,
+		latency:   latency,
+		mask:      0,
+		pending:   0,
+		encoder:   encoder,
+		vectors:   make([]int, VECTORS),
+		installed: make([]bool, VECTORS),
+		inService: make([]int, 0),
+		decoder:   parser.NewDecoder(wordLength),
+	}
+}
+
+func (controller *controller) Name() string {
+	return "interrupt"
+}
+
+func (controller *controller) Range() (int, int) {
+	return controller.base, MASK + 1
+}
+
+// Run serves the accesses to the vector table and the mask register
+func (controller *controller) Run(bus b.Instance) {
+	controller.port = b.NewPort(controller, controller.decoder, controller.latency)
+	controller.port.Run(bus)
+}
+
+func (controller *controller) Read(position int) []parser.Msg {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	value := *controller.register(position)
+	if position != MASK && !controller.installed[position] {
+		value = -1
+	}
+
+	return controller.encoder.MapParams([]string{strconv.Itoa(value)})
+}
+
+func (controller *controller) Write(position int, payload []parser.Msg) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	value := controller.decoder.Value(payload)
+	if position == MASK {
+		controller.mask = value
+		return
+	}
+
+	controller.installed[position] = value >= 0
+	controller.vectors[position] = 0
+
+	if value >= 0 {
+		controller.vectors[position] = value
+	}
+}
+
+func (controller *controller) register(position int) *int {
+	if position == MASK {
+		return &controller.mask
+	}
+
+	return &controller.vectors[position]
+}
+
+// Raise asserts a hardware line
+func (controller *controller) Raise(line int) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	controller.pending |= 1 << uint(line)
+}
+
+// Pending returns the unmasked pending line with the highest priority, as
+// long as it has a higher priority than the lines being serviced
+func (controller *controller) Pending() (int, bool) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	limit := LINES
+	if len(controller.inService) > 0 {
+		limit = controller.inService[len(controller.inService)-1]
+	}
+
+	for line := 0; line < limit; line++ {
+		bit := 1 << uint(line)
+
+		if controller.pending&bit != 0 && controller.mask&bit == 0 {
+			return line, true
+		}
+	}
+
+	return 0, false
+}
+
+// Acknowledge clears a pending line and marks it as being serviced
+func (controller *controller) Acknowledge(line int) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	controller.pending &^= 1 << uint(line)
+	controller.inService = append(controller.inService, line)
+}
+
+// Complete ends the service of the last acknowledged line
+func (controller *controller) Complete() {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	if len(controller.inService) > 0 {
+		controller.inService = controller.inService[:len(controller.inService)-1]
+	}
+}
+
+// Vector returns the label of the handler of a vector, telling if one is
+// installed
+func (controller *controller) Vector(vector int) (int, bool) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	return controller.vectors[vector], controller.installed[vector]
+}
+
+// Snapshot returns the state of the controller
+func (controller *controller) Snapshot() Snapshot {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	return Snapshot{
+		Mask:      controller.mask,
+		Pending:   controller.pending,
+		Vectors:   append([]int{}, controller.vectors...),
+		Installed: append([]bool{}, controller.installed...),
+		InService: append([]int{}, controller.inService...),
+		Port:      controller.port.Snapshot(),
+	}
+}
+
+// LoadSnapshot moves the controller to a saved state once it runs on the
+// bus
+func (controller *controller) LoadSnapshot(bus b.Instance, snapshot Snapshot) {
+	controller.mutex.Lock()
+	defer controller.mutex.Unlock()
+
+	controller.mask = snapshot.Mask
+	controller.pending = snapshot.Pending
+	controller.inService = append([]int{}, snapshot.InService...)
+	copy(controller.vectors, snapshot.Vectors)
+	copy(controller.installed, snapshot.Installed)
+	controller.port.LoadSnapshot(bus, snapshot.Port)
+}
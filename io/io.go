@@ -2,77 +2,152 @@ package io
 
 import (
 	"bufio"
+	"errors"
 	"os"
 	"path/filepath"
 
+	"github.com/bruunoromero/cpu-emulator/logger"
 	"github.com/bruunoromero/cpu-emulator/parser"
 	"github.com/bruunoromero/cpu-emulator/utils"
 
 	b "github.com/bruunoromero/cpu-emulator/bus"
 )
 
+// programAddress is the address where the program is loaded
+const programAddress = 0
+
+// Statement is a line of the program producing instructions, starting at
+// Address and counted from 1
+type Statement struct {
+	Line    int
+	Text    string
+	Address int
+	Size    int
+}
+
 type io struct {
-	read    chan []parser.Msg
-	write   <-chan string
+	path    string
+	read    [][]parser.Msg
+	source  []Statement
 	encoder parser.Encoder
+	log     logger.Logger
 }
 
 // Instance is the interface of the io type
 type Instance interface {
 	Run(b.Instance)
+	Done() bool
+	Load(string) error
+	Path() string
+	Source() []Statement
+	Snapshot() Snapshot
+	LoadSnapshot(Snapshot)
+}
+
+// Snapshot is the state of the io, the program and the expressions not
+// written to the memory yet
+type Snapshot struct {
+	Path   string
+	Read   [][]parser.Msg
+	Source []Statement
 }
 
 // New returns a new instance of the I/O Module
 func New(encoder parser.Encoder) Instance {
 	return &io{
 		encoder: encoder,
-		read:    make(chan []parser.Msg),
+		read:    make([][]parser.Msg, 0),
+		source:  make([]Statement, 0),
+		log:     logger.For(logger.IO),
 	}
 }
 
-// Run will start the Cycle from read and write from I/O
-func (io *io) Run(bus b.Instance) {
-	go func() {
-		path, fileErr := filepath.Abs("./code.s")
+// Load reads the program of a source file, replacing the one read before
+func (io *io) Load(file string) error {
+	path, fileErr := filepath.Abs(file)
 
-		if fileErr != nil {
-			utils.Abort("Could not get path of the file")
-		}
+	if fileErr != nil {
+		return errors.New("Could not get path of the file")
+	}
 
-		inFile, openErr := os.Open(path)
+	inFile, openErr := os.Open(path)
 
-		if openErr != nil {
-			utils.Abort("Could not open the file")
+	if openErr != nil {
+		return errors.New("Could not open the file")
+	}
+
+	defer inFile.Close()
+	scanner := bufio.NewScanner(inFile)
+	scanner.Split(bufio.ScanLines)
+
+	io.path = path
+	io.read = make([][]parser.Msg, 0)
+	io.source = make([]Statement, 0)
+
+	codeIndex := 0
+	for line := 1; scanner.Scan(); line++ {
+		start := codeIndex
+		instructions := io.encoder.ExpandInstruction(scanner.Text())
+		for _, instruction := range instructions {
+			io.read = append(io.read, io.encoder.Parse(codeIndex, instruction)...)
+			codeIndex++
 		}
 
-		defer inFile.Close()
-		scanner := bufio.NewScanner(inFile)
-		scanner.Split(bufio.ScanLines)
-
-		codeIndex := 0
-		for scanner.Scan() {
-			instructions := io.encoder.ExpandInstruction(scanner.Text())
-			exprs := make([][]parser.Msg, 0)
-			for _, instruction := range instructions {
-				exprs = append(exprs, io.encoder.Parse(codeIndex, instruction)...)
-				codeIndex++
-			}
+		if codeIndex > start {
+			io.source = append(io.source, Statement{Line: line, Text: scanner.Text(), Address: start, Size: codeIndex - start})
+		}
+	}
 
-			for _, expr := range exprs {
-				io.read <- expr
-			}
+	io.log.Info("program read", "path", path, "instructions", codeIndex)
 
+	return nil
+}
+
+// Run will load the program, unless one was loaded before, and write one
+// expression to the memory per cycle
+func (io *io) Run(bus b.Instance) {
+	if io.path == "" {
+		if err := io.Load("./code.s"); err != nil {
+			utils.Abort(err.Error())
 		}
-	}()
-
-	for {
-		select {
-		case stdin, ok := <-io.read:
-			if !ok {
-				break
-			} else {
-				bus.SendTo("memory", "io", b.WRITE, stdin)
+	}
+
+	bus.Kernel().Every(1, func() {
+		if len(io.read) > 0 {
+			bus.Route("io", b.WRITE, programAddress, io.read[0])
+			io.read = io.read[1:]
+
+			if len(io.read) == 0 {
+				io.log.Debug("program written", "cycle", bus.Kernel().Cycle())
 			}
 		}
-	}
+	})
+}
+
+// Path returns the absolute path of the program
+func (io *io) Path() string {
+	return io.path
+}
+
+// Source returns the lines of the program
+func (io *io) Source() []Statement {
+	return io.source
+}
+
+// Done tells if the whole program was written to the memory
+func (io *io) Done() bool {
+	return len(io.read) == 0
+}
+
+// Snapshot returns the state of the io
+func (io *io) Snapshot() Snapshot {
+	return Snapshot{Path: io.path, Read: io.read, Source: io.source}
+}
+
+// LoadSnapshot moves the io to a saved state, before it runs so the
+// program is not read again
+func (io *io) LoadSnapshot(snapshot Snapshot) {
+	io.path = snapshot.Path
+	io.read = append([][]parser.Msg{}, snapshot.Read...)
+	io.source = append([]Statement{}, snapshot.Source...)
 }
@@ -0,0 +1,243 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// This constants are the levels of the messages, a component only writes
+// the messages at or above its level
+const (
+	DEBUG = iota
+	INFO
+	WARN
+	ERROR
+	// OFF silences a component
+	OFF
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+// This constants are the output formats
+const (
+	// TEXT writes a line with the level, the component, the message and
+	// the fields as key=value pairs
+	TEXT = iota
+	// JSON writes a JSON object per line
+	JSON
+)
+
+var formatNames = []string{"text", "json"}
+
+// This constants are the components of the machine
+const (
+	CPU    = "cpu"
+	MEMORY = "memory"
+	BUS    = "bus"
+	IO     = "io"
+)
+
+// Components lists the components writing messages
+var Components = []string{CPU, MEMORY, BUS, IO}
+
+// ParseLevel returns the level with the given name
+func ParseLevel(name string) (int, bool) {
+	return parse(levelNames, name)
+}
+
+// ParseFormat returns the output format with the given name
+func ParseFormat(name string) (int, bool) {
+	return parse(formatNames, name)
+}
+
+func parse(names []string, name string) (int, bool) {
+	for value, current := range names {
+		if current == strings.ToLower(name) {
+			return value, true
+		}
+	}
+
+	return 0, false
+}
+
+// Config holds the level of every component and where the messages are
+// written
+type Config struct {
+	// Level applies to the components without their own level
+	Level      int
+	Components map[string]int
+	Format     int
+	Output     io.Writer
+}
+
+// Default returns the configuration used until Configure is called, the
+// informational messages are written to the standard error as text
+func Default() Config {
+	return Config{
+		Level:      INFO,
+		Components: make(map[string]int),
+		Format:     TEXT,
+		Output:     os.Stderr,
+	}
+}
+
+var (
+	mutex  sync.Mutex
+	config = Default()
+)
+
+// Configure replaces the configuration of every logger
+func Configure(next Config) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if next.Components == nil {
+		next.Components = make(map[string]int)
+	}
+
+	if next.Output == nil {
+		next.Output = os.Stderr
+	}
+
+	config = next
+}
+
+// Logger writes the messages of a component. The fields are given as
+// alternating keys and values
+type Logger struct {
+	component string
+	fields    []interface{}
+}
+
+// For returns the logger of a component
+func For(component string) Logger {
+	return Logger{component: component}
+}
+
+// With returns a logger adding fields to every message
+func (logger Logger) With(fields ...interface{}) Logger {
+	merged := make([]interface{}, 0, len(logger.fields)+len(fields))
+	merged = append(merged, logger.fields...)
+	merged = append(merged, fields...)
+
+	return Logger{component: logger.component, fields: merged}
+}
+
+// Enabled tells if the messages of a level are written
+func (logger Logger) Enabled(level int) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return logger.enabled(level)
+}
+
+func (logger Logger) enabled(level int) bool {
+	threshold, ok := config.Components[logger.component]
+
+	if !ok {
+		threshold = config.Level
+	}
+
+	return level < OFF && level >= threshold
+}
+
+// Debug writes a message tracing the work of the component
+func (logger Logger) Debug(message string, fields ...interface{}) {
+	logger.write(DEBUG, message, fields)
+}
+
+// Info writes a message about the effects of the component
+func (logger Logger) Info(message string, fields ...interface{}) {
+	logger.write(INFO, message, fields)
+}
+
+// Warn writes a message about an unexpected condition
+func (logger Logger) Warn(message string, fields ...interface{}) {
+	logger.write(WARN, message, fields)
+}
+
+// Error writes a message about a failure
+func (logger Logger) Error(message string, fields ...interface{}) {
+	logger.write(ERROR, message, fields)
+}
+
+func (logger Logger) write(level int, message string, fields []interface{}) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if !logger.enabled(level) {
+		return
+	}
+
+	fields = append(append([]interface{}{}, logger.fields...), fields...)
+
+	var line string
+
+	if config.Format == JSON {
+		line = logger.json(level, message, fields)
+	} else {
+		line = logger.text(level, message, fields)
+	}
+
+	fmt.Fprintln(config.Output, line)
+}
+
+// pairs calls visit for every key and value of the fields, a key without
+// value is paired with nil
+func pairs(fields []interface{}, visit func(string, interface{})) {
+	for index := 0; index < len(fields); index += 2 {
+		var value interface{}
+
+		if index+1 < len(fields) {
+			value = fields[index+1]
+		}
+
+		visit(fmt.Sprint(fields[index]), value)
+	}
+}
+
+func (logger Logger) text(level int, message string, fields []interface{}) string {
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "%-5s %s: %s", strings.ToUpper(levelNames[level]), logger.component, message)
+
+	pairs(fields, func(key string, value interface{}) {
+		fmt.Fprintf(&builder, " %s=%v", key, value)
+	})
+
+	return builder.String()
+}
+
+// json writes the fields in the given order, after the level, the
+// component and the message
+func (logger Logger) json(level int, message string, fields []interface{}) string {
+	var builder strings.Builder
+	separator := "{"
+
+	entry := func(key string, value interface{}) {
+		encodedKey, _ := json.Marshal(key)
+		encodedValue, err := json.Marshal(value)
+
+		if err != nil {
+			encodedValue, _ = json.Marshal(fmt.Sprint(value))
+		}
+
+		builder.WriteString(separator)
+		builder.Write(encodedKey)
+		builder.WriteString(":")
+		builder.Write(encodedValue)
+		separator = ","
+	}
+
+	entry("level", levelNames[level])
+	entry("component", logger.component)
+	entry("message", message)
+	pairs(fields, entry)
+	builder.WriteString("}")
+
+	return builder.String()
+}
@@ -1,8 +1,18 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/logger"
+	"github.com/bruunoromero/cpu-emulator/predictor"
+	"github.com/bruunoromero/cpu-emulator/timing"
 	"github.com/bruunoromero/cpu-emulator/vm"
 )
 
@@ -39,15 +49,203 @@ func getFrequency() int {
 
 	for {
 		fmt.Scanf("%d", &vl)
-		return int(vl)
+		if vl >= 1 {
+			return int(vl)
+		}
+	}
+}
+
+func getEntries(value string) ([]int, bool) {
+	entries := make([]int, 0)
+
+	if value == "" {
+		return entries, true
+	}
+
+	for _, label := range strings.Split(value, ",") {
+		entry, err := strconv.Atoi(strings.TrimSpace(label))
+		if err != nil || entry < 0 {
+			return nil, false
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, true
+}
+
+// getLogLevels parses the levels of the components, given as
+// comma-separated component=level pairs
+func getLogLevels(value string) (map[string]int, bool) {
+	levels := make(map[string]int)
+
+	if value == "" {
+		return levels, true
 	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || !isComponent(parts[0]) {
+			return nil, false
+		}
+
+		level, ok := logger.ParseLevel(parts[1])
+		if !ok {
+			return nil, false
+		}
+
+		levels[parts[0]] = level
+	}
+
+	return levels, true
+}
+
+func isComponent(name string) bool {
+	for _, component := range logger.Components {
+		if component == name {
+			return true
+		}
+	}
+
+	return false
 }
 
 func main() {
+	pipeline := flag.Bool("pipeline", false, "execute the instructions in a 5-stage pipeline")
+	forwarding := flag.Bool("forwarding", false, "forward the results between the pipeline stages")
+	diagram := flag.Bool("diagram", false, "log the pipeline stages once per cycle")
+	branches := flag.String("predictor", "none", "branch predictor: none, taken, not-taken, 1bit, 2bit or gshare")
+	coherence := flag.String("coherence", "mesi", "cache coherence protocol: none, msi or mesi")
+	l1i := flag.String("l1i", "", "comma-separated key=value pairs overriding the instruction cache: size, line, ways, replacement, write and latency, or off")
+	l1d := flag.String("l1d", "", "comma-separated key=value pairs overriding the data cache: size, line, ways, replacement, write and latency, or off")
+	l2 := flag.String("l2", "", "comma-separated key=value pairs overriding the unified cache: size, line, ways, replacement, write and latency, or off")
+	cycles := flag.String("costs", "", "comma-separated key=value pairs overriding the cycles of alu, multiply, divide, branch, system, memory, device and transfer")
+	arbitration := flag.String("arbitration", "fifo", "bus arbitration: fifo, round-robin, priority or tdma")
+	trace := flag.String("trace", "", "file recording the messages of the bus")
+	format := flag.String("trace-format", "jsonl", "format of the trace: jsonl or csv")
+	waveform := flag.String("vcd", "", "Value Change Dump file recording the lanes of the bus")
+	spans := flag.String("timeline", "", "trace-event JSON file recording the activity of the machine")
+	cores := flag.Int("cores", 1, "number of cores sharing the bus and the memory")
+	starts := flag.String("entries", "", "comma-separated labels where every core starts, 0 is the beginning of the program")
+	debug := flag.Bool("debug", false, "pause before the first instruction and read debugger commands")
+	gdb := flag.String("gdb", "", "TCP address where a GDB remote serial protocol server waits for GDB, like localhost:1234")
+	dap := flag.String("dap", "", "TCP address where a Debug Adapter Protocol server waits for an editor, like localhost:4711")
+	snapshot := flag.String("snapshot", "", "file where the state of the machine is saved")
+	snapshotAt := flag.Int("snapshot-at", 0, "cycle at the end of which the snapshot is taken")
+	restore := flag.String("restore", "", "snapshot file the machine is restored from, replacing the machine flags and questions")
+	logLevel := flag.String("log-level", "info", "level of the log messages: debug, info, warn, error or off")
+	logFormat := flag.String("log-format", "text", "format of the log messages: text or json")
+	logLevels := flag.String("log", "", "comma-separated component=level pairs overriding the level of cpu, memory, bus or io")
+	logFile := flag.String("log-file", "", "file receiving the log messages instead of the standard error")
+	flag.Parse()
 
-	frequency := getFrequency()
-	bus := getBusLength()
-	word := getWordLengh()
+	logs := logger.Default()
+	if level, ok := logger.ParseLevel(*logLevel); ok {
+		logs.Level = level
+	} else {
+		fmt.Println("Unknown log level:", *logLevel)
+		return
+	}
+
+	if parsed, ok := logger.ParseFormat(*logFormat); ok {
+		logs.Format = parsed
+	} else {
+		fmt.Println("Unknown log format:", *logFormat)
+		return
+	}
+
+	if levels, ok := getLogLevels(*logLevels); ok {
+		logs.Components = levels
+	} else {
+		fmt.Println("Invalid log levels:", *logLevels)
+		return
+	}
+
+	if *logFile != "" {
+		file, err := os.Create(*logFile)
+		if err != nil {
+			fmt.Println("Could not create the log file:", *logFile)
+			return
+		}
+
+		defer file.Close()
+		logs.Output = file
+	}
+
+	logger.Configure(logs)
+
+	entries, ok := getEntries(*starts)
+	if !ok {
+		fmt.Println("Invalid entry points:", *starts)
+		return
+	}
+
+	prediction := predictor.Default()
+	if kind, ok := predictor.Parse(*branches); ok {
+		prediction.Kind = kind
+	} else {
+		fmt.Println("Unknown branch predictor:", *branches)
+		return
+	}
+
+	caches := cache.Default()
+	if protocol, ok := cache.ParseCoherence(*coherence); ok {
+		caches.Coherence = protocol
+	} else {
+		fmt.Println("Unknown coherence protocol:", *coherence)
+		return
+	}
+
+	for _, level := range []struct {
+		value  string
+		config *cache.Config
+	}{
+		{*l1i, &caches.Instruction},
+		{*l1d, &caches.Data},
+		{*l2, &caches.Unified},
+	} {
+		parsed, ok := cache.ParseConfig(level.value, *level.config)
+		if !ok {
+			fmt.Println("Invalid cache level:", level.value)
+			return
+		}
+
+		*level.config = parsed
+	}
+
+	if !caches.Valid() {
+		fmt.Println("Invalid cache hierarchy, the first level lines must fit in the second level lines")
+		return
+	}
+
+	costs, ok := timing.Parse(*cycles, timing.Default())
+	if !ok || !costs.Valid() {
+		fmt.Println("Invalid costs:", *cycles)
+		return
+	}
+
+	policy := b.FIFO
+	if parsed, ok := b.ParseArbitration(*arbitration); ok {
+		policy = parsed
+	} else {
+		fmt.Println("Unknown bus arbitration:", *arbitration)
+		return
+	}
+
+	traceFormat := b.JSONLINES
+	if parsed, ok := b.ParseTraceFormat(*format); ok {
+		traceFormat = parsed
+	} else {
+		fmt.Println("Unknown trace format:", *format)
+		return
+	}
+
+	var frequency, bus, word int
+	if *restore == "" {
+		frequency = getFrequency()
+		bus = getBusLength()
+		word = getWordLengh()
+	}
 
 	fmt.Println("")
 	fmt.Println("-----------------------")
@@ -57,5 +255,37 @@ func main() {
 	fmt.Println("Log: VM Started")
 	fmt.Println("")
 
-	vm.Start([]string{"A", "B", "C", "D", "E"}, bus, word, 1024, frequency)
+	vm.Start(vm.Config{
+		Machine: vm.Machine{
+			Registers:    []string{"A", "B", "C", "D", "E"},
+			BusLength:    bus,
+			WordLength:   word,
+			MemoryLength: 1024,
+			Frequency:    frequency,
+			Costs:        costs,
+			Caches:       caches,
+			Pipeline: cpu.Pipeline{
+				Enabled:    *pipeline,
+				Forwarding: *forwarding,
+				Diagram:    *diagram,
+			},
+			Predictor:   prediction,
+			Cores:       *cores,
+			Entries:     entries,
+			Arbitration: policy,
+		},
+		Trace:       *trace,
+		TraceFormat: traceFormat,
+		Waveform:    *waveform,
+		Timeline:    *spans,
+		Debug:       *debug,
+		Remote:      *gdb,
+		Adapter:     *dap,
+		Snapshot:    *snapshot,
+		SnapshotAt:  *snapshotAt,
+		Restore:     *restore,
+	})
+
+	fmt.Println("")
+	fmt.Println("Log: VM Stopped")
 }
@@ -1,34 +1,47 @@
 package memory
 
 import (
-	"time"
+	"sync"
 
 	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/logger"
 	"github.com/bruunoromero/cpu-emulator/parser"
 	"github.com/bruunoromero/cpu-emulator/utils"
 )
 
 type memory struct {
-	frequency         int
 	wordLength        int
+	latency           int
+	cores             []string
 	lastWritePosition int
 	list              [][]parser.Msg
-	messageQueue      []parser.Msg
+	port              *b.Port
+	mutex             sync.RWMutex
 	decoder           parser.Decoder
+	log               logger.Logger
 }
 
 // Instance is the interface for the memory type
 type Instance interface {
-	Run(bus b.Instance)
-	Read(byte) []parser.Msg
-	Write(byte, []parser.Msg)
+	b.Device
+	Snapshot() Snapshot
+	LoadSnapshot(b.Instance, Snapshot)
 	write(int, []parser.Msg) int
 }
 
+// Snapshot is the state of the memory, the positions never written are
+// empty
+type Snapshot struct {
+	List              [][]parser.Msg
+	LastWritePosition int
+	Port              b.PortSnapshot
+}
+
 type I = Instance
 
-// New returns a new instance of Memory
-func New(size int, wordLength int, frequency int) Instance {
+// New returns a new instance of Memory, answering the transactions after
+// latency cycles. The cores are notified of every instruction loaded
+func New(size int, wordLength int, latency int, cores []string) Instance {
 	wordLengthByte := wordLength / 8
 
 	maxWords := size / wordLengthByte
@@ -40,62 +53,131 @@ func New(size int, wordLength int, frequency int) Instance {
 
 	return &memory{
 		lastWritePosition: 0,
-		frequency:         frequency,
 		wordLength:        wordLength,
-		messageQueue:      make([]parser.Msg, 0),
+		latency:           latency,
+		cores:             cores,
 		list:              make([][]parser.Msg, length),
 		decoder:           parser.NewDecoder(wordLength),
+		log:               logger.For(logger.MEMORY),
 	}
 }
 
+func (memory *memory) Name() string {
+	return "memory"
+}
+
+// Range maps the memory at the beginning of the address space
+func (memory *memory) Range() (int, int) {
+	return 0, len(memory.list)
+}
+
 func (memory *memory) Run(bus b.Instance) {
-	go func() {
-		for {
-			data := bus.ReceiveFrom("memoryData")
-			address := bus.ReceiveFrom("memoryAddress")
-			instructions := bus.ReceiveFrom("memoryInstruction")
-
-			messages := memory.decoder.GetMessagesWithQueue(address.Payload, data.Payload, instructions.Payload, &memory.messageQueue)
-
-			for _, message := range messages {
-				if len(message) > 0 {
-					msg := message[0]
-
-					if msg.Signal == b.WRITE {
-						if msg.Origin == "io" {
-							position := memory.write(0, message)
-							bus.SendTo("cpu", "memory", b.READ, []parser.Msg{parser.Msg{Key: position, Index: 0, Lenght: 0, Type: parser.REGISTER, Value: byte(position)}})
-						} else if msg.Origin == "cpu" {
-							memory.write(len(memory.list)/2, message)
-						}
-					} else if msg.Signal == b.READ {
-						v := memory.Read(msg.Value)
-						bus.SendTo(msg.Origin, "memory", b.WRITE, v)
-					}
-				}
-			}
+	memory.port = b.NewPort(memory, memory.decoder, memory.latency)
+
+	bus.Kernel().Every(1, func() {
+		memory.cycle(bus, memory.port)
+	})
+}
+
+func (memory *memory) cycle(bus b.Instance, port *b.Port) {
+	for _, message := range port.Receive(bus) {
+		msg := message[0]
 
-			time.Sleep(time.Second / (time.Duration(memory.frequency) * 4))
+		if msg.Signal == b.WRITE && msg.Origin == "io" {
+			position := memory.write(0, message)
+			memory.log.Debug("instruction loaded", "cycle", bus.Kernel().Cycle(), "position", position)
+
+			for _, core := range memory.cores {
+				bus.SendTo(core, memory.Name(), b.READ, []parser.Msg{parser.Msg{Key: position, Index: 0, Lenght: 0, Type: parser.REGISTER, Value: byte(position)}})
+			}
+		} else {
+			port.Serve(bus, message)
 		}
-	}()
+	}
 }
 
-func (memory *memory) Read(payload byte) []parser.Msg {
-	return memory.list[payload]
+// zero returns the word read from a position never written
+func (memory *memory) zero() []parser.Msg {
+	msgs := make([]parser.Msg, 0)
+
+	for _, value := range utils.ToBytes(memory.wordLength, 0) {
+		msgs = append(msgs, parser.Msg{Type: parser.LITERAL, Value: value})
+	}
+
+	return msgs
 }
 
-func (memory *memory) Write(position byte, payload []parser.Msg) {
-	memory.list[position] = payload
+// Read returns a copy of a position, the stored words are owned by the
+// memory. Positions never written are read as zero
+func (memory *memory) Read(payload int) []parser.Msg {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	if memory.list[payload] == nil {
+		return memory.zero()
+	}
+
+	return clone(memory.list[payload])
+}
+
+func (memory *memory) Write(position int, payload []parser.Msg) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	memory.list[position] = clone(payload)
+}
+
+func clone(payload []parser.Msg) []parser.Msg {
+	if payload == nil {
+		return nil
+	}
+
+	tmp := make([]parser.Msg, len(payload))
+	copy(tmp, payload)
+
+	return tmp
 }
 
 func (memory *memory) write(offset int, payload []parser.Msg) int {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
 	if memory.lastWritePosition == len(memory.list) {
 		memory.lastWritePosition = 0
 	}
 
 	position := memory.lastWritePosition + offset
-	memory.list[position] = payload
+	memory.list[position] = clone(payload)
 	memory.lastWritePosition++
 
 	return position
 }
+
+// Snapshot returns the state of the memory
+func (memory *memory) Snapshot() Snapshot {
+	memory.mutex.RLock()
+	defer memory.mutex.RUnlock()
+
+	list := make([][]parser.Msg, len(memory.list))
+	for position, payload := range memory.list {
+		list[position] = clone(payload)
+	}
+
+	return Snapshot{List: list, LastWritePosition: memory.lastWritePosition, Port: memory.port.Snapshot()}
+}
+
+// LoadSnapshot moves the memory to a saved state once it runs on the bus
+func (memory *memory) LoadSnapshot(bus b.Instance, snapshot Snapshot) {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+
+	for position := range memory.list {
+		memory.list[position] = nil
+		if position < len(snapshot.List) {
+			memory.list[position] = clone(snapshot.List[position])
+		}
+	}
+
+	memory.lastWritePosition = snapshot.LastWritePosition
+	memory.port.LoadSnapshot(bus, snapshot.Port)
+}
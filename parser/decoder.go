@@ -59,12 +59,22 @@ func (decoder *Decoder) isMsgComplete(msg []Msg) bool {
 	return firstMsg.Lenght == len(msg)-1
 }
 
+// sameMessage tells if two messages belong to the same transaction, keys
+// are only unique for a given origin
+func sameMessage(left Msg, right Msg) bool {
+	return left.Origin == right.Origin && left.Key == right.Key
+}
+
 func (decoder *Decoder) groupMessages(msgs []Msg) [][]Msg {
 	groups := make([][]Msg, 0)
 	tmp := make([]Msg, len(msgs))
 	copy(tmp, msgs)
 
 	slice.Sort(tmp, func(left int, right int) bool {
+		if tmp[left].Origin != tmp[right].Origin {
+			return tmp[left].Origin < tmp[right].Origin
+		}
+
 		return tmp[left].Key < tmp[right].Key
 	})
 
@@ -77,7 +87,7 @@ func (decoder *Decoder) groupMessages(msgs []Msg) [][]Msg {
 				groups = append(groups, group)
 			}
 		} else {
-			if tmp[index-1].Key == msg.Key {
+			if sameMessage(tmp[index-1], msg) {
 				group = append(group, msg)
 
 				if index == len(tmp)-1 {
@@ -89,6 +99,10 @@ func (decoder *Decoder) groupMessages(msgs []Msg) [][]Msg {
 
 				group = make([]Msg, 0)
 				group = append(group, msg)
+
+				if index == len(tmp)-1 {
+					groups = append(groups, group)
+				}
 			}
 		}
 	}
@@ -108,6 +122,20 @@ func getValue(msg Msg) byte {
 	return msg.Value
 }
 
+// Value decodes a word of messages into its value
+func (decoder *Decoder) Value(payload []Msg) int {
+	return utils.FromBytes(decoder.wordLength, mapSlice(payload, getValue))
+}
+
+// Transaction splits the messages of a bus transaction into its address
+// and data
+func (decoder *Decoder) Transaction(payload []Msg) (int, []Msg) {
+	tmp := Sorted(payload)
+	size := decoder.wordLength / 8
+
+	return decoder.Value(tmp[:size]), tmp[size:]
+}
+
 // Decode decodes an array of messages into an action
 func (decoder *Decoder) Decode(payload []Msg) Action {
 
@@ -128,7 +156,7 @@ func (decoder *Decoder) Decode(payload []Msg) Action {
 		Action: actionConst,
 	}
 
-	if actionConst == NULL {
+	if actionConst == NULL || len(tmp) == numBytes {
 		return action
 	}
 
@@ -188,9 +216,11 @@ func (decoder *Decoder) GetMessagesWithQueue(address []Msg, data []Msg, instruct
 
 	groups := decoder.groupMessages(*queue)
 
+	// The incomplete messages wait in the queue for the rest of their
+	// words, the complete ones behind them are not held back
 	for _, msgs := range groups {
 		if !decoder.isMsgComplete(msgs) {
-			break
+			continue
 		}
 
 		messages = append(messages, msgs)
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 
@@ -21,6 +22,14 @@ const (
 	GTEQ
 	LTEQ
 	Label
+	Ei
+	Di
+	Iret
+	Idiv
+	Halt
+	Xchg
+	Cmpxchg
+	Xadd
 )
 
 // This constants represents all possible types of messages
@@ -49,18 +58,26 @@ type Msg struct {
 }
 
 var actions = map[string]byte{
-	"EQ":    EQ,
-	"GT":    GT,
-	"LT":    LT,
-	"mov":   Mov,
-	"add":   Add,
-	"inc":   Inc,
-	"JMP":   Jump,
-	"imul":  Imul,
-	"NULL":  NULL,
-	"GTEQ":  GTEQ,
-	"LTEQ":  LTEQ,
-	"label": Label,
+	"EQ":      EQ,
+	"GT":      GT,
+	"LT":      LT,
+	"mov":     Mov,
+	"add":     Add,
+	"inc":     Inc,
+	"JMP":     Jump,
+	"imul":    Imul,
+	"NULL":    NULL,
+	"GTEQ":    GTEQ,
+	"LTEQ":    LTEQ,
+	"label":   Label,
+	"ei":      Ei,
+	"di":      Di,
+	"iret":    Iret,
+	"idiv":    Idiv,
+	"hlt":     Halt,
+	"xchg":    Xchg,
+	"cmpxchg": Cmpxchg,
+	"xadd":    Xadd,
 }
 
 var conditionals = map[string]string{
@@ -194,6 +211,47 @@ func (encoder *Encoder) MapParams(params []string) []Msg {
 	return prs
 }
 
+// Transaction returns the messages of a bus transaction, an address word
+// followed by the data
+func (encoder *Encoder) Transaction(key int, address int, data []Msg) []Msg {
+	payload := encoder.expandValue(address, MEMORY)
+	payload = append(payload, Sorted(data)...)
+
+	for index := range payload {
+		payload[index].Key = key
+		payload[index].Index = index
+		payload[index].Lenght = len(payload) - 1
+	}
+
+	return payload
+}
+
+// Keyed returns a copy of a payload in index order, keyed as a single
+// message
+func Keyed(key int, payload []Msg) []Msg {
+	tmp := Sorted(payload)
+
+	for index := range tmp {
+		tmp[index].Key = key
+		tmp[index].Index = index
+		tmp[index].Lenght = len(tmp) - 1
+	}
+
+	return tmp
+}
+
+// Sorted returns a copy of a payload in index order
+func Sorted(payload []Msg) []Msg {
+	tmp := make([]Msg, len(payload))
+	copy(tmp, payload)
+
+	sort.SliceStable(tmp, func(left int, right int) bool {
+		return tmp[left].Index < tmp[right].Index
+	})
+
+	return tmp
+}
+
 func (encoder *Encoder) expandValue(value int, msgType int) []Msg {
 	var msgs []Msg
 	bytes := utils.ToBytes(encoder.wordLength, value)
@@ -219,3 +277,14 @@ func getAction(action string) byte {
 
 	return val
 }
+
+// Mnemonic returns the name of an action in the source code
+func Mnemonic(action int) string {
+	for name, value := range actions {
+		if int(value) == action {
+			return name
+		}
+	}
+
+	return strconv.Itoa(action)
+}
@@ -0,0 +1,187 @@
+package predictor
+
+// This constants are the kinds of predictors. A branch is taken when a
+// conditional holds or when a jump is executed
+const (
+	// NONE disables the prediction, the instructions are fetched in order
+	NONE = iota
+	// TAKEN predicts every branch as taken
+	TAKEN
+	// NOTTAKEN predicts every branch as not taken
+	NOTTAKEN
+	// ONEBIT predicts the last outcome of the branch
+	ONEBIT
+	// TWOBIT predicts with a saturating counter per branch
+	TWOBIT
+	// GSHARE predicts with saturating counters indexed by the address of
+	// the branch xor the global history
+	GSHARE
+)
+
+var kinds = map[string]int{
+	"none":      NONE,
+	"taken":     TAKEN,
+	"not-taken": NOTTAKEN,
+	"1bit":      ONEBIT,
+	"2bit":      TWOBIT,
+	"gshare":    GSHARE,
+}
+
+// Parse returns the kind of predictor with the given name
+func Parse(name string) (int, bool) {
+	kind, ok := kinds[name]
+	return kind, ok
+}
+
+// Config describes a predictor
+type Config struct {
+	Kind int
+	// Entries is the number of entries of the tables
+	Entries int
+	// History is the number of outcomes kept by gshare
+	History int
+	// Penalty is the number of cycles added by a misprediction
+	Penalty int
+}
+
+// Stats holds the counters of a predictor
+type Stats struct {
+	Branches       int
+	Mispredictions int
+}
+
+// Accuracy returns the fraction of the branches predicted correctly
+func (stats Stats) Accuracy() float64 {
+	if stats.Branches == 0 {
+		return 0
+	}
+
+	return float64(stats.Branches-stats.Mispredictions) / float64(stats.Branches)
+}
+
+type predictor struct {
+	config   Config
+	counters []int
+	history  int
+	stats    Stats
+}
+
+// Instance is the interface of the predictor type
+type Instance interface {
+	Predict(int) bool
+	Resolve(int, bool, bool) bool
+	Penalty() int
+	Stats() Stats
+	Snapshot() Snapshot
+	LoadSnapshot(Snapshot)
+}
+
+// Snapshot is the state of a predictor
+type Snapshot struct {
+	Counters []int
+	History  int
+	Stats    Stats
+}
+
+// Default returns the predictor used when none is configured
+func Default() Config {
+	return Config{
+		Kind:    NONE,
+		Entries: 64,
+		History: 6,
+		Penalty: 1,
+	}
+}
+
+// New returns a new instance of predictor, or nil when the prediction is
+// disabled
+func New(config Config) Instance {
+	if config.Kind == NONE {
+		return nil
+	}
+
+	if config.Entries < 1 {
+		config.Entries = 1
+	}
+
+	return &predictor{
+		config:   config,
+		counters: make([]int, config.Entries),
+	}
+}
+
+func (predictor *predictor) Penalty() int {
+	return predictor.config.Penalty
+}
+
+func (predictor *predictor) Stats() Stats {
+	return predictor.stats
+}
+
+// Snapshot returns the state of the predictor
+func (predictor *predictor) Snapshot() Snapshot {
+	return Snapshot{Counters: append([]int{}, predictor.counters...), History: predictor.history, Stats: predictor.stats}
+}
+
+// LoadSnapshot moves the predictor to a saved state
+func (predictor *predictor) LoadSnapshot(snapshot Snapshot) {
+	copy(predictor.counters, snapshot.Counters)
+	predictor.history = snapshot.History
+	predictor.stats = snapshot.Stats
+}
+
+// Predict tells if the branch at an address is predicted as taken
+func (predictor *predictor) Predict(pc int) bool {
+	switch predictor.config.Kind {
+	case TAKEN:
+		return true
+	case NOTTAKEN:
+		return false
+	case ONEBIT:
+		return predictor.counters[predictor.index(pc)] == 1
+	default:
+		return predictor.counters[predictor.index(pc)] >= 2
+	}
+}
+
+// Resolve trains the predictor with the outcome of a branch, telling if it
+// was mispredicted
+func (predictor *predictor) Resolve(pc int, predicted bool, taken bool) bool {
+	index := predictor.index(pc)
+	counter := &predictor.counters[index]
+
+	switch predictor.config.Kind {
+	case ONEBIT:
+		*counter = 0
+		if taken {
+			*counter = 1
+		}
+	case TWOBIT, GSHARE:
+		if taken && *counter < 3 {
+			*counter++
+		} else if !taken && *counter > 0 {
+			*counter--
+		}
+	}
+
+	predictor.history = (predictor.history << 1) & (1<<uint(predictor.config.History) - 1)
+	if taken {
+		predictor.history |= 1
+	}
+
+	predictor.stats.Branches++
+	if predicted != taken {
+		predictor.stats.Mispredictions++
+		return true
+	}
+
+	return false
+}
+
+func (predictor *predictor) index(pc int) int {
+	if predictor.config.Kind == GSHARE {
+		pc ^= predictor.history
+	}
+
+	return pc % len(predictor.counters)
+}
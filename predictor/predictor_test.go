@@ -0,0 +1,146 @@
+package predictor
+
+import "testing"
+
+// TestTwoBit trains the counter of a branch, which saturates at 0 and 3
+// and predicts taken from 2
+func TestTwoBit(t *testing.T) {
+	steps := []struct {
+		taken     bool
+		counter   int
+		predicted bool
+	}{
+		{true, 1, false},
+		{true, 2, true},
+		{true, 3, true},
+		{true, 3, true},
+		{false, 2, true},
+		{false, 1, false},
+		{false, 0, false},
+		{false, 0, false},
+		{true, 1, false},
+	}
+
+	predictor := New(Config{Kind: TWOBIT, Entries: 4, History: 2})
+	mispredictions := 0
+
+	for index, step := range steps {
+		predicted := predictor.Predict(5)
+		if predictor.Resolve(5, predicted, step.taken) {
+			mispredictions++
+		}
+
+		snapshot := predictor.Snapshot()
+		if counter := snapshot.Counters[1]; counter != step.counter {
+			t.Errorf("step %d: counter %d, want %d", index, counter, step.counter)
+		}
+
+		if predicted := predictor.Predict(5); predicted != step.predicted {
+			t.Errorf("step %d: predicted %v, want %v", index, predicted, step.predicted)
+		}
+	}
+
+	stats := predictor.Stats()
+	if stats.Branches != len(steps) || stats.Mispredictions != mispredictions {
+		t.Errorf("stats %+v, want %d branches and %d mispredictions", stats, len(steps), mispredictions)
+	}
+}
+
+// TestGshare trains the counters indexed by the address of a branch xor
+// the history of the last two outcomes
+func TestGshare(t *testing.T) {
+	steps := []struct {
+		pc      int
+		taken   bool
+		index   int
+		counter int
+		history int
+	}{
+		{4, true, 4, 1, 1},
+		{4, true, 5, 1, 3},
+		{4, true, 7, 1, 3},
+		{4, true, 7, 2, 3},
+		{4, false, 7, 1, 2},
+		{9, true, 11, 1, 1},
+		{4, false, 5, 0, 2},
+	}
+
+	predictor := New(Config{Kind: GSHARE, Entries: 16, History: 2})
+
+	for index, step := range steps {
+		predictor.Resolve(step.pc, predictor.Predict(step.pc), step.taken)
+
+		snapshot := predictor.Snapshot()
+		if counter := snapshot.Counters[step.index]; counter != step.counter {
+			t.Errorf("step %d: counter %d is %d, want %d", index, step.index, counter, step.counter)
+		}
+
+		if snapshot.History != step.history {
+			t.Errorf("step %d: history %b, want %b", index, snapshot.History, step.history)
+		}
+	}
+
+	// The history 3 selects the counter 7 of the branch 4, which is 1
+	predictor.Resolve(4, false, true)
+	predictor.Resolve(4, false, true)
+	if predictor.Predict(4) {
+		t.Errorf("predicted taken with the counter %d", predictor.Snapshot().Counters[7])
+	}
+}
+
+func TestOneBit(t *testing.T) {
+	predictor := New(Config{Kind: ONEBIT, Entries: 4})
+
+	for index, taken := range []bool{true, false, false, true, true} {
+		predictor.Resolve(2, predictor.Predict(2), taken)
+
+		if predicted := predictor.Predict(2); predicted != taken {
+			t.Errorf("step %d: predicted %v, want the last outcome %v", index, predicted, taken)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	tests := []struct {
+		kind      int
+		predicted bool
+	}{
+		{TAKEN, true},
+		{NOTTAKEN, false},
+	}
+
+	for _, test := range tests {
+		predictor := New(Config{Kind: test.kind, Entries: 4})
+
+		for _, taken := range []bool{true, false, true} {
+			predicted := predictor.Predict(1)
+			if predicted != test.predicted {
+				t.Errorf("kind %d: predicted %v, want %v", test.kind, predicted, test.predicted)
+			}
+
+			if mispredicted := predictor.Resolve(1, predicted, taken); mispredicted != (predicted != taken) {
+				t.Errorf("kind %d: mispredicted %v for the outcome %v", test.kind, mispredicted, taken)
+			}
+		}
+
+		if accuracy := predictor.Stats().Accuracy(); accuracy < 0.33 || accuracy > 0.67 {
+			t.Errorf("kind %d: accuracy %.2f, want a third or two thirds", test.kind, accuracy)
+		}
+	}
+
+	if New(Config{Kind: NONE}) != nil {
+		t.Errorf("the disabled predictor was built")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for name, kind := range map[string]int{"none": NONE, "taken": TAKEN, "not-taken": NOTTAKEN, "1bit": ONEBIT, "2bit": TWOBIT, "gshare": GSHARE} {
+		if parsed, ok := Parse(name); !ok || parsed != kind {
+			t.Errorf("Parse(%q) = %d, %v, want %d", name, parsed, ok, kind)
+		}
+	}
+
+	if _, ok := Parse("3bit"); ok {
+		t.Errorf("Parse accepted an unknown predictor")
+	}
+}
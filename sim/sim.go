@@ -0,0 +1,234 @@
+package sim
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+)
+
+type event struct {
+	cycle  int
+	order  int
+	tick   int
+	action func()
+}
+
+type events []event
+
+func (events events) Len() int {
+	return len(events)
+}
+
+func (events events) Less(i int, j int) bool {
+	if events[i].cycle == events[j].cycle {
+		return events[i].order < events[j].order
+	}
+
+	return events[i].cycle < events[j].cycle
+}
+
+func (events events) Swap(i int, j int) {
+	events[i], events[j] = events[j], events[i]
+}
+
+func (events *events) Push(value interface{}) {
+	*events = append(*events, value.(event))
+}
+
+func (events *events) Pop() interface{} {
+	old := *events
+	last := old[len(old)-1]
+	*events = old[:len(old)-1]
+
+	return last
+}
+
+type kernel struct {
+	cycle     int
+	order     int
+	frequency int
+	stopped   bool
+	queue     *events
+	ticks     []func()
+	posted    []func()
+	mutex     sync.Mutex
+}
+
+// Snapshot is the state of the kernel, the periodic actions are saved by
+// their position in the order they were registered with Every
+type Snapshot struct {
+	Cycle int
+	Order int
+	Ticks []Event
+}
+
+// Event is a pending run of a periodic action
+type Event struct {
+	Tick  int
+	Cycle int
+	Order int
+}
+
+// Instance is the interface of the discrete-event kernel. Every component
+// of the machine schedules its work at future cycles of a global counter,
+// events of the same cycle running in the order they were scheduled.
+//
+// The machine state is owned by the goroutine calling Run, other goroutines
+// must only touch it through actions given to Post
+type Instance interface {
+	Run()
+	Stop()
+	Post(func())
+	Cycle() int
+	Frequency() int
+	Schedule(int, func()) int
+	ScheduleAt(int, int, func())
+	Every(int, func())
+	Snapshot() Snapshot
+	LoadSnapshot(Snapshot) error
+}
+
+// New returns a new instance of the kernel for a clock of the given
+// frequency in MHz
+func New(frequency int) Instance {
+	return &kernel{
+		cycle:     0,
+		order:     0,
+		frequency: frequency,
+		stopped:   false,
+		queue:     &events{},
+		ticks:     make([]func(), 0),
+		posted:    make([]func(), 0),
+	}
+}
+
+// Cycle returns the current cycle
+func (kernel *kernel) Cycle() int {
+	return kernel.cycle
+}
+
+// Frequency returns the frequency of the clock in MHz
+func (kernel *kernel) Frequency() int {
+	return kernel.frequency
+}
+
+// Schedule runs an action after the given number of cycles and returns
+// its order among the events of that cycle
+func (kernel *kernel) Schedule(delay int, action func()) int {
+	return kernel.push(kernel.cycle+delay, -1, action)
+}
+
+// ScheduleAt runs an action at a cycle with the order it was given when it
+// was scheduled, it restores the events of a snapshot
+func (kernel *kernel) ScheduleAt(cycle int, order int, action func()) {
+	heap.Push(kernel.queue, event{cycle: cycle, order: order, tick: -1, action: action})
+}
+
+// Every runs an action once every period, starting on the current cycle
+func (kernel *kernel) Every(period int, action func()) {
+	var tick func()
+	id := len(kernel.ticks)
+
+	tick = func() {
+		action()
+		kernel.push(kernel.cycle+period, id, tick)
+	}
+
+	kernel.ticks = append(kernel.ticks, tick)
+	kernel.push(kernel.cycle, id, tick)
+}
+
+func (kernel *kernel) push(cycle int, tick int, action func()) int {
+	kernel.order++
+	heap.Push(kernel.queue, event{cycle: cycle, order: kernel.order, tick: tick, action: action})
+
+	return kernel.order
+}
+
+// Snapshot returns the state of the kernel. The events given to Schedule
+// are left to their owners, which save them along with their own state
+func (kernel *kernel) Snapshot() Snapshot {
+	ticks := make([]Event, 0)
+
+	for _, next := range *kernel.queue {
+		if next.tick >= 0 {
+			ticks = append(ticks, Event{Tick: next.tick, Cycle: next.cycle, Order: next.order})
+		}
+	}
+
+	return Snapshot{Cycle: kernel.cycle, Order: kernel.order, Ticks: ticks}
+}
+
+// LoadSnapshot moves the kernel to a saved state, the same periodic actions
+// must have been registered with Every. The pending events are dropped
+func (kernel *kernel) LoadSnapshot(snapshot Snapshot) error {
+	if len(snapshot.Ticks) != len(kernel.ticks) {
+		return errors.New("The periodic actions do not match the snapshot")
+	}
+
+	kernel.cycle = snapshot.Cycle
+	kernel.order = snapshot.Order
+	kernel.queue = &events{}
+
+	for _, next := range snapshot.Ticks {
+		if next.Tick < 0 || next.Tick >= len(kernel.ticks) {
+			return errors.New("The periodic actions do not match the snapshot")
+		}
+
+		heap.Push(kernel.queue, event{cycle: next.Cycle, order: next.Order, tick: next.Tick, action: kernel.ticks[next.Tick]})
+	}
+
+	return nil
+}
+
+// Run executes the events as fast as possible until the kernel is stopped
+// or there are no events left
+func (kernel *kernel) Run() {
+	for {
+		kernel.drain()
+
+		if kernel.isStopped() || kernel.queue.Len() == 0 {
+			return
+		}
+
+		next := heap.Pop(kernel.queue).(event)
+		kernel.cycle = next.cycle
+		next.action()
+	}
+}
+
+// Stop ends the simulation after the current event, it is safe to call it
+// from any goroutine
+func (kernel *kernel) Stop() {
+	kernel.mutex.Lock()
+	defer kernel.mutex.Unlock()
+
+	kernel.stopped = true
+}
+
+// Post runs an action on the goroutine of the kernel before the next event,
+// it is safe to call it from any goroutine
+func (kernel *kernel) Post(action func()) {
+	kernel.mutex.Lock()
+	defer kernel.mutex.Unlock()
+
+	kernel.posted = append(kernel.posted, action)
+}
+
+func (kernel *kernel) isStopped() bool {
+	kernel.mutex.Lock()
+	defer kernel.mutex.Unlock()
+
+	return kernel.stopped
+}
+
+func (kernel *kernel) drain() {
+	kernel.mutex.Lock()
+	posted := kernel.posted
+	kernel.posted = make([]func(), 0)
+	kernel.mutex.Unlock()
+
+	for _, action := range posted {
+		action()
+	}
+}
@@ -0,0 +1,218 @@
+package timeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+)
+
+// event is an entry of the trace-event format, the timestamps are given in
+// microseconds
+type event struct {
+	Name      string            `json:"name"`
+	Category  string            `json:"cat,omitempty"`
+	Phase     string            `json:"ph"`
+	Timestamp float64           `json:"ts"`
+	Duration  float64           `json:"dur,omitempty"`
+	Process   int               `json:"pid"`
+	Thread    int               `json:"tid"`
+	ID        int               `json:"id,omitempty"`
+	Args      map[string]string `json:"args,omitempty"`
+}
+
+// track identifies the events of a thread of a process
+type track struct {
+	process string
+	thread  string
+}
+
+// message identifies a message on the bus
+type message struct {
+	origin      string
+	destination string
+	lane        string
+	key         int
+}
+
+type timeline struct {
+	path      string
+	frequency int
+	events    []event
+	processes map[string]int
+	threads   map[track]int
+	async     int
+	queued    map[message][]int
+	served    map[message]int
+}
+
+// Instance is the interface of the timeline type. Every component of the
+// machine is a process whose activities are spans on its threads, the bus
+// and the memory are fed by the messages of the bus, as a bus tracer
+type Instance interface {
+	Span(string, string, string, int, int)
+	Async(string, string, string, int, int)
+	Record(b.Event)
+	Close() error
+}
+
+// New returns a new instance of timeline, written as a trace-event JSON
+// file once it is closed. The cycles are converted with the frequency of
+// the machine in MHz
+func New(path string, frequency int) Instance {
+	if frequency < 1 {
+		frequency = 1
+	}
+
+	return &timeline{
+		path:      path,
+		frequency: frequency,
+		events:    make([]event, 0),
+		processes: make(map[string]int),
+		threads:   make(map[track]int),
+		queued:    make(map[message][]int),
+		served:    make(map[message]int),
+	}
+}
+
+// Span records an activity of a thread between two cycles, the spans of a
+// thread must not overlap
+func (timeline *timeline) Span(process string, thread string, name string, start int, end int) {
+	pid, tid := timeline.track(process, thread)
+
+	timeline.events = append(timeline.events, event{
+		Name:      name,
+		Category:  process,
+		Phase:     "X",
+		Timestamp: timeline.time(start),
+		Duration:  timeline.time(end - start),
+		Process:   pid,
+		Thread:    tid,
+	})
+}
+
+// Async records an activity of a thread between two cycles, which may
+// overlap the other ones
+func (timeline *timeline) Async(process string, thread string, name string, start int, end int) {
+	pid, tid := timeline.track(process, thread)
+	timeline.async++
+
+	for _, phase := range []struct {
+		name  string
+		cycle int
+	}{{"b", start}, {"e", end}} {
+		timeline.events = append(timeline.events, event{
+			Name:      name,
+			Category:  process,
+			Phase:     phase.name,
+			Timestamp: timeline.time(phase.cycle),
+			Process:   pid,
+			Thread:    tid,
+			ID:        timeline.async,
+		})
+	}
+}
+
+// Record turns the messages of the bus into spans. A message waits on the
+// bus from the cycle it is sent until it is delivered, the requests
+// delivered to the memory are served until it sends their replies
+func (timeline *timeline) Record(trace b.Event) {
+	id := message{origin: trace.Origin, destination: trace.Destination, lane: trace.Lane, key: trace.Key}
+
+	switch trace.Kind {
+	case b.ENQUEUE:
+		timeline.queued[id] = append(timeline.queued[id], trace.Cycle)
+
+		if trace.Signal == "REPLY" {
+			timeline.reply(trace)
+		}
+	case b.DELIVER:
+		if len(timeline.queued[id]) == 0 {
+			return
+		}
+
+		start := timeline.queued[id][0]
+		timeline.queued[id] = timeline.queued[id][1:]
+		name := fmt.Sprintf("%s %s to %s #%d", trace.Signal, trace.Origin, trace.Destination, trace.Key)
+		timeline.Async("bus", trace.Lane, name, start, trace.Cycle)
+
+		if trace.Origin == "io" {
+			timeline.Async("io", "program", fmt.Sprintf("load #%d", trace.Key), start, trace.Cycle)
+		}
+
+		if trace.Destination == "memory" && trace.Lane == b.ADDRESS {
+			timeline.served[message{origin: trace.Origin, key: trace.Key}] = trace.Cycle
+		}
+	}
+}
+
+// reply ends the span of the memory serving the request of a reply
+func (timeline *timeline) reply(trace b.Event) {
+	if trace.Origin != "memory" {
+		return
+	}
+
+	request := message{origin: trace.Destination, key: trace.Key}
+
+	start, ok := timeline.served[request]
+	if !ok {
+		return
+	}
+
+	delete(timeline.served, request)
+	timeline.Async("memory", "port", fmt.Sprintf("%s #%d", trace.Destination, trace.Key), start, trace.Cycle)
+}
+
+// Close writes the timeline
+func (timeline *timeline) Close() error {
+	file, err := os.Create(timeline.path)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	if err := encoder.Encode(map[string][]event{"traceEvents": timeline.events}); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// track returns the identifiers of a thread, naming the process and the
+// thread the first time they are used
+func (timeline *timeline) track(process string, thread string) (int, int) {
+	pid, ok := timeline.processes[process]
+	if !ok {
+		pid = len(timeline.processes) + 1
+		timeline.processes[process] = pid
+		timeline.metadata("process_name", process, pid, 0)
+	}
+
+	key := track{process: process, thread: thread}
+
+	tid, ok := timeline.threads[key]
+	if !ok {
+		tid = len(timeline.threads) + 1
+		timeline.threads[key] = tid
+		timeline.metadata("thread_name", thread, pid, tid)
+	}
+
+	return pid, tid
+}
+
+func (timeline *timeline) metadata(kind string, name string, pid int, tid int) {
+	timeline.events = append(timeline.events, event{
+		Name:    kind,
+		Phase:   "M",
+		Process: pid,
+		Thread:  tid,
+		Args:    map[string]string{"name": name},
+	})
+}
+
+// time converts a number of cycles into microseconds
+func (timeline *timeline) time(cycles int) float64 {
+	return float64(cycles) / float64(timeline.frequency)
+}
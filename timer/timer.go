@@ -0,0 +1,142 @@
+package timer
+
+import (
+	"strconv"
+	"sync"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// This constants are the offsets of the timer registers
+const (
+	// INTERVAL is the number of bus cycles between two interrupts
+	INTERVAL = iota
+	// CONTROL enables the timer when set to 1
+	CONTROL
+	// COUNTER holds the cycles elapsed since the last interrupt
+	COUNTER
+)
+
+// Instance is the interface of the timer, its registers are mapped on the
+// bus
+type Instance interface {
+	b.Device
+	Snapshot() Snapshot
+	LoadSnapshot(b.Instance, Snapshot)
+}
+
+// Snapshot is the state of the timer
+type Snapshot struct {
+	Interval int
+	Control  int
+	Counter  int
+	Port     b.PortSnapshot
+}
+
+type timer struct {
+	base       int
+	latency    int
+	line       int
+	interval   int
+	control    int
+	counter    int
+	port       *b.Port
+	mutex      sync.Mutex
+	encoder    parser.Encoder
+	decoder    parser.Decoder
+	interrupts interrupt.Instance
+}
+
+// New returns a new instance of the timer, raising the given interrupt
+// line every time the interval elapses
+func New(base int, line int, wordLength int, latency int, encoder parser.Encoder, interrupts interrupt.Instance) Instance {
+	return &timer{
+		base:       base,
+		latency:    latency,
+		line:       line,
+		interval:   0,
+		control:    0,
+		counter:    0,
+		encoder:    encoder,
+		interrupts: interrupts,
+		decoder:    parser.NewDecoder(wordLength),
+	}
+}
+
+func (timer *timer) Name() string {
+	return "timer"
+}
+
+func (timer *timer) Range() (int, int) {
+	return timer.base, COUNTER + 1
+}
+
+// Run serves the accesses to the registers, the counting is driven by the
+// bus cycles
+func (timer *timer) Run(bus b.Instance) {
+	timer.port = b.NewPort(timer, timer.decoder, timer.latency)
+	timer.port.Run(bus)
+}
+
+// Tick counts a bus cycle
+func (timer *timer) Tick() {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+
+	if timer.control != 1 || timer.interval <= 0 {
+		return
+	}
+
+	timer.counter++
+
+	if timer.counter >= timer.interval {
+		timer.counter = 0
+		timer.interrupts.Raise(timer.line)
+	}
+}
+
+func (timer *timer) Read(position int) []parser.Msg {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+
+	return timer.encoder.MapParams([]string{strconv.Itoa(*timer.register(position))})
+}
+
+func (timer *timer) Write(position int, payload []parser.Msg) {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+
+	*timer.register(position) = timer.decoder.Value(payload)
+}
+
+func (timer *timer) register(position int) *int {
+	switch position {
+	case INTERVAL:
+		return &timer.interval
+	case CONTROL:
+		return &timer.control
+	default:
+		return &timer.counter
+	}
+}
+
+// Snapshot returns the state of the timer
+func (timer *timer) Snapshot() Snapshot {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+
+	return Snapshot{Interval: timer.interval, Control: timer.control, Counter: timer.counter, Port: timer.port.Snapshot()}
+}
+
+// LoadSnapshot moves the timer to a saved state once it runs on the bus
+func (timer *timer) LoadSnapshot(bus b.Instance, snapshot Snapshot) {
+	timer.mutex.Lock()
+	defer timer.mutex.Unlock()
+
+	timer.interval = snapshot.Interval
+	timer.control = snapshot.Control
+	timer.counter = snapshot.Counter
+	timer.port.LoadSnapshot(bus, snapshot.Port)
+}
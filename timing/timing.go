@@ -0,0 +1,138 @@
+package timing
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bruunoromero/cpu-emulator/parser"
+)
+
+// Costs holds the number of cycles taken by every operation of the machine
+type Costs struct {
+	// Alu is the cost of mov, add, inc and of the atomic instructions,
+	// whose memory accesses are timed by the bus
+	Alu int
+	// Multiply is the cost of imul
+	Multiply int
+	// Divide is the cost of idiv
+	Divide int
+	// Branch is the cost of the conditionals, jumps and labels
+	Branch int
+	// System is the cost of ei, di, iret and hlt
+	System int
+	// Memory is the latency of the memory to answer a transaction
+	Memory int
+	// Device is the latency of the other devices to answer a transaction
+	Device int
+	// Transfer is the number of cycles between two transfers of the bus
+	Transfer int
+}
+
+// Default returns the costs used when none are configured
+func Default() Costs {
+	return Costs{
+		Alu:      1,
+		Multiply: 3,
+		Divide:   10,
+		Branch:   1,
+		System:   2,
+		Memory:   4,
+		Device:   1,
+		Transfer: 4,
+	}
+}
+
+// Valid tells if the machine can run with the costs, every instruction and
+// every transfer of the bus takes at least a cycle. The devices may answer
+// on the cycle of the request
+func (costs Costs) Valid() bool {
+	for _, cost := range []int{costs.Alu, costs.Multiply, costs.Divide, costs.Branch, costs.System, costs.Transfer} {
+		if cost < 1 {
+			return false
+		}
+	}
+
+	return costs.Memory >= 0 && costs.Device >= 0
+}
+
+// Parse overrides the costs with comma-separated key=value pairs, the keys
+// are alu, multiply, divide, branch, system, memory, device and transfer.
+// The costs parsed are not checked, see Valid
+func Parse(value string, costs Costs) (Costs, bool) {
+	if value == "" {
+		return costs, true
+	}
+
+	fields := map[string]*int{
+		"alu":      &costs.Alu,
+		"multiply": &costs.Multiply,
+		"divide":   &costs.Divide,
+		"branch":   &costs.Branch,
+		"system":   &costs.System,
+		"memory":   &costs.Memory,
+		"device":   &costs.Device,
+		"transfer": &costs.Transfer,
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 {
+			return costs, false
+		}
+
+		field, ok := fields[parts[0]]
+		if !ok {
+			return costs, false
+		}
+
+		cost, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return costs, false
+		}
+
+		*field = cost
+	}
+
+	return costs, true
+}
+
+// Of returns the cost of an action
+func (costs Costs) Of(action int) int {
+	switch action {
+	case parser.Mov, parser.Add, parser.Inc, parser.Xchg, parser.Cmpxchg, parser.Xadd:
+		return costs.Alu
+	case parser.Imul:
+		return costs.Multiply
+	case parser.Idiv:
+		return costs.Divide
+	case parser.Ei, parser.Di, parser.Iret, parser.Halt:
+		return costs.System
+	default:
+		return costs.Branch
+	}
+}
+
+// Report summarizes the timing of a run
+type Report struct {
+	Cycles       int
+	Instructions int
+	Frequency    int
+}
+
+// CPI returns the average number of cycles per instruction
+func (report Report) CPI() float64 {
+	if report.Instructions == 0 {
+		return 0
+	}
+
+	return float64(report.Cycles) / float64(report.Instructions)
+}
+
+// Elapsed returns the simulated time in microseconds
+func (report Report) Elapsed() float64 {
+	if report.Frequency == 0 {
+		return 0
+	}
+
+	return float64(report.Cycles) / float64(report.Frequency)
+}
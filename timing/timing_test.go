@@ -0,0 +1,59 @@
+package timing
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		costs Costs
+		ok    bool
+	}{
+		{"", Default(), true},
+		{"alu=2", Costs{Alu: 2, Multiply: 3, Divide: 10, Branch: 1, System: 2, Memory: 4, Device: 1, Transfer: 4}, true},
+		{"multiply=5, divide=20,transfer=1", Costs{Alu: 1, Multiply: 5, Divide: 20, Branch: 1, System: 2, Memory: 4, Device: 1, Transfer: 1}, true},
+		{"branch=0,system=-1,memory=0,device=0", Costs{Alu: 1, Multiply: 3, Divide: 10, Branch: 0, System: -1, Memory: 0, Device: 0, Transfer: 4}, true},
+		{"alu", Default(), false},
+		{"alu=x", Default(), false},
+		{"cache=1", Default(), false},
+	}
+
+	for _, test := range tests {
+		costs, ok := Parse(test.value, Default())
+		if ok != test.ok {
+			t.Errorf("Parse(%q) ok = %v, want %v", test.value, ok, test.ok)
+			continue
+		}
+
+		if ok && costs != test.costs {
+			t.Errorf("Parse(%q) = %+v, want %+v", test.value, costs, test.costs)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		change func(*Costs)
+		valid  bool
+	}{
+		{func(costs *Costs) {}, true},
+		{func(costs *Costs) { costs.Memory = 0 }, true},
+		{func(costs *Costs) { costs.Device = 0 }, true},
+		{func(costs *Costs) { costs.Memory = -1 }, false},
+		{func(costs *Costs) { costs.Device = -1 }, false},
+		{func(costs *Costs) { costs.Alu = 0 }, false},
+		{func(costs *Costs) { costs.Multiply = 0 }, false},
+		{func(costs *Costs) { costs.Divide = 0 }, false},
+		{func(costs *Costs) { costs.Branch = 0 }, false},
+		{func(costs *Costs) { costs.System = -2 }, false},
+		{func(costs *Costs) { costs.Transfer = 0 }, false},
+	}
+
+	for index, test := range tests {
+		costs := Default()
+		test.change(&costs)
+
+		if valid := costs.Valid(); valid != test.valid {
+			t.Errorf("case %d: Valid() of %+v = %v, want %v", index, costs, valid, test.valid)
+		}
+	}
+}
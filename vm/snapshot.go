@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/disk"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
+	"github.com/bruunoromero/cpu-emulator/io"
+	"github.com/bruunoromero/cpu-emulator/memory"
+	"github.com/bruunoromero/cpu-emulator/predictor"
+	"github.com/bruunoromero/cpu-emulator/sim"
+	"github.com/bruunoromero/cpu-emulator/timer"
+)
+
+// VERSION is the version of the snapshot files, the files of other
+// versions are refused
+const VERSION = 1
+
+// Snapshot is the state of the whole machine at the end of a cycle, along
+// with the configuration it was built with
+type Snapshot struct {
+	Version    int
+	Machine    Machine
+	Kernel     sim.Snapshot
+	Bus        b.Snapshot
+	IO         io.Snapshot
+	Memory     memory.Snapshot
+	Interrupts interrupt.Snapshot
+	Timer      timer.Snapshot
+	Disk       disk.Snapshot
+	Cores      []Core
+}
+
+// Core is the state of a core, its caches and its predictor. The disabled
+// caches and predictor are nil
+type Core struct {
+	CPU         cpu.Snapshot
+	Instruction *cache.Snapshot
+	Data        *cache.Snapshot
+	Unified     *cache.Snapshot
+	Predictor   *predictor.Snapshot
+}
+
+// components are the parts of the machine saved in the snapshots
+type components struct {
+	kernel     sim.Instance
+	bus        b.Instance
+	io         io.Instance
+	memory     memory.Instance
+	interrupts interrupt.Instance
+	timer      timer.Instance
+	disk       disk.Instance
+	cores      []cpu.Instance
+	caches     []cache.Levels
+	branches   []predictor.Instance
+}
+
+// Load reads a snapshot file, refusing the ones of other versions
+func Load(path string) (Snapshot, error) {
+	var snapshot Snapshot
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return snapshot, errors.New("Could not read the snapshot file")
+	}
+
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return snapshot, errors.New("Could not decode the snapshot file")
+	}
+
+	if snapshot.Version != VERSION {
+		return snapshot, fmt.Errorf("Unsupported snapshot version %d, expected %d", snapshot.Version, VERSION)
+	}
+
+	return snapshot, nil
+}
+
+// save writes the state of the machine to a snapshot file
+func (parts components) save(path string, machine Machine) error {
+	image, err := parts.disk.Snapshot()
+	if err != nil {
+		return errors.New("Could not read the disk image")
+	}
+
+	snapshot := Snapshot{
+		Version:    VERSION,
+		Machine:    machine,
+		Kernel:     parts.kernel.Snapshot(),
+		Bus:        parts.bus.Snapshot(),
+		IO:         parts.io.Snapshot(),
+		Memory:     parts.memory.Snapshot(),
+		Interrupts: parts.interrupts.Snapshot(),
+		Timer:      parts.timer.Snapshot(),
+		Disk:       image,
+		Cores:      make([]Core, len(parts.cores)),
+	}
+
+	for id, core := range parts.cores {
+		snapshot.Cores[id] = Core{
+			CPU:         core.Snapshot(),
+			Instruction: saveCache(parts.caches[id].Instruction),
+			Data:        saveCache(parts.caches[id].Data),
+			Unified:     saveCache(parts.caches[id].Unified),
+		}
+
+		if parts.branches[id] != nil {
+			branches := parts.branches[id].Snapshot()
+			snapshot.Cores[id].Predictor = &branches
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.New("Could not encode the snapshot")
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return errors.New("Could not write the snapshot file")
+	}
+
+	return nil
+}
+
+// restore moves the machine to the state of a snapshot, once every
+// component runs on the bus. The kernel is restored first so the replies
+// pending in the ports are scheduled on it
+func (parts components) restore(snapshot Snapshot) error {
+	if len(snapshot.Cores) != len(parts.cores) {
+		return errors.New("The cores do not match the snapshot")
+	}
+
+	if err := parts.kernel.LoadSnapshot(snapshot.Kernel); err != nil {
+		return err
+	}
+
+	parts.bus.LoadSnapshot(snapshot.Bus)
+	parts.memory.LoadSnapshot(parts.bus, snapshot.Memory)
+	parts.interrupts.LoadSnapshot(parts.bus, snapshot.Interrupts)
+	parts.timer.LoadSnapshot(parts.bus, snapshot.Timer)
+
+	if err := parts.disk.LoadSnapshot(parts.bus, snapshot.Disk); err != nil {
+		return errors.New("Could not write the disk image")
+	}
+
+	for id, core := range parts.cores {
+		saved := snapshot.Cores[id]
+
+		core.LoadSnapshot(saved.CPU)
+		restoreCache(parts.caches[id].Instruction, saved.Instruction)
+		restoreCache(parts.caches[id].Data, saved.Data)
+		restoreCache(parts.caches[id].Unified, saved.Unified)
+
+		if parts.branches[id] != nil && saved.Predictor != nil {
+			parts.branches[id].LoadSnapshot(*saved.Predictor)
+		}
+	}
+
+	return nil
+}
+
+func saveCache(level cache.Instance) *cache.Snapshot {
+	if level == nil {
+		return nil
+	}
+
+	snapshot := level.Snapshot()
+	return &snapshot
+}
+
+func restoreCache(level cache.Instance, snapshot *cache.Snapshot) {
+	if level != nil && snapshot != nil {
+		level.LoadSnapshot(*snapshot)
+	}
+}
@@ -0,0 +1,7 @@
+mov B, 0
+label 1
+mov A, 1
+xadd 0x010, A
+inc B
+B < 5 : JMP 1 : NULL
+hlt
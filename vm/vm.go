@@ -1,33 +1,417 @@
 package vm
 
 import (
+	"fmt"
 	"sync"
 
-	"github.com/bruunoromero/cpu-emulator/bus"
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
 	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/debugger"
+	"github.com/bruunoromero/cpu-emulator/disk"
+	"github.com/bruunoromero/cpu-emulator/interrupt"
 	"github.com/bruunoromero/cpu-emulator/io"
 	"github.com/bruunoromero/cpu-emulator/memory"
 	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/predictor"
+	"github.com/bruunoromero/cpu-emulator/sim"
+	"github.com/bruunoromero/cpu-emulator/timeline"
+	"github.com/bruunoromero/cpu-emulator/timer"
+	"github.com/bruunoromero/cpu-emulator/timing"
+	"github.com/bruunoromero/cpu-emulator/utils"
 )
 
 var once sync.Once
 
-// Start initiates the Von Neumann loop
-func Start(registers []string, busLength int, wordLength int, memoryLength int, frequency int) {
+// This constants are the base addresses of the memory-mapped devices
+const (
+	INTERRUPTBASE = b.IOBASE
+	TIMERBASE     = b.IOBASE + 0x20
+	DISKBASE      = b.IOBASE + 0x30
+)
+
+// This constants are the interrupt lines of the devices
+const (
+	TIMERLINE = iota
+	DISKLINE
+)
+
+// DISKIMAGE is the file backing the disk
+const DISKIMAGE = "./disk.img"
+
+// Machine describes the machine built by Start, it is saved along with
+// the state of the machine in the snapshots
+type Machine struct {
+	Registers    []string
+	BusLength    int
+	WordLength   int
+	MemoryLength int
+	// Frequency is the clock of the machine in MHz
+	Frequency int
+	Costs     timing.Costs
+	// Caches are the levels of every core, with their sizes and the
+	// latencies spent on their lookups
+	Caches    cache.Hierarchy
+	Pipeline  cpu.Pipeline
+	Predictor predictor.Config
+	// Cores is the number of cores sharing the bus and the memory, every
+	// core has its own registers, caches and predictor
+	Cores int
+	// Entries are the labels where the cores start, the cores without
+	// one start at the beginning of the program
+	Entries []int
+	// Arbitration is the policy granting the bus to its masters
+	Arbitration int
+}
+
+// Config describes the machine built by Start and how it is observed
+type Config struct {
+	Machine
+	// Trace is the file recording the messages of the bus in the trace
+	// format, no trace is recorded when it is empty
+	Trace       string
+	TraceFormat int
+	// Waveform is the Value Change Dump file recording the lanes of the
+	// bus, no waveform is recorded when it is empty
+	Waveform string
+	// Timeline is the trace-event JSON file recording the activity of the
+	// cores, the memory, the io and the bus, none is recorded when it is
+	// empty
+	Timeline string
+	// Debug pauses the machine before the first instruction and reads
+	// debugger commands from the standard input
+	Debug bool
+	// Remote is the TCP address where a GDB remote serial protocol server
+	// waits for GDB before the machine starts, none is started when it is
+	// empty
+	Remote string
+	// Adapter is the TCP address where a Debug Adapter Protocol server
+	// waits for an editor before the machine starts, none is started when
+	// it is empty
+	Adapter string
+	// Snapshot is the file where the state of the machine is saved at the
+	// end of the cycle SnapshotAt, none is saved when it is empty
+	Snapshot   string
+	SnapshotAt int
+	// Restore is the snapshot file the machine is restored from, its
+	// machine replaces the one of the configuration and its disk image
+	// replaces the image of the disk. The program is not read again
+	Restore string
+	// Program is the source file of the program, ./code.s is read when it
+	// is empty. The program launched by the editor replaces it
+	Program string
+	// Limit stops the machine at the given cycle when it is still running,
+	// it runs until it stops by itself when it is 0
+	Limit int
+}
+
+// Start initiates the Von Neumann loop and returns when the machine stops
+func Start(config Config) {
 	once.Do(func() {
-		encoder := parser.NewEncoder(registers, wordLength)
+		run(config)
+	})
+}
+
+// run builds the machine, runs it until it stops and reports it. It
+// returns the parts of the stopped machine
+func run(config Config) components {
+	// The restored machine is built the way it was saved, only the
+	// diagram of the pipeline is taken from the configuration
+	var saved Snapshot
+	if config.Restore != "" {
+		var err error
+		if saved, err = Load(config.Restore); err != nil {
+			utils.Abort(err.Error())
+		}
+
+		diagram := config.Pipeline.Diagram
+		config.Machine = saved.Machine
+		config.Pipeline.Diagram = diagram
+	}
+
+	wordLength := config.WordLength
+	costs := config.Costs
+	if !costs.Valid() {
+		utils.Abort("Every instruction and bus transfer must cost at least one cycle")
+	}
+
+	registers := append(append([]string{}, config.Registers...), cpu.IDREGISTER)
+	encoder := parser.NewEncoder(registers, wordLength)
+	words := (config.MemoryLength / (wordLength / 8)) / 4
+
+	kernel := sim.New(config.Frequency)
+	io := io.New(encoder)
+	bus := b.New(kernel, config.BusLength, costs.Transfer, b.NewArbiter(config.Arbitration))
+	if config.Trace != "" {
+		tracer, err := b.NewTracer(config.Trace, config.TraceFormat)
+		if err != nil {
+			utils.Abort("Could not create the trace file")
+		}
+
+		bus.Trace(tracer)
+		defer tracer.Close()
+	}
+
+	if config.Waveform != "" {
+		waveform := b.NewVCD(config.Waveform, config.BusLength, config.Frequency)
+
+		bus.Trace(waveform)
+		defer func() {
+			if err := waveform.Close(); err != nil {
+				utils.Abort("Could not write the waveform file")
+			}
+		}()
+	}
+
+	var spans timeline.Instance
+	if config.Timeline != "" {
+		spans = timeline.New(config.Timeline, config.Frequency)
+
+		bus.Trace(spans)
+		defer func() {
+			if err := spans.Close(); err != nil {
+				utils.Abort("Could not write the timeline file")
+			}
+		}()
+	}
+
+	var debug debugger.Instance
+	var hook cpu.Hook
+	if config.Debug {
+		debug = debugger.New(kernel, bus, io, registers, wordLength)
+	}
+
+	if config.Remote != "" {
+		remote, err := debugger.Listen(config.Remote, kernel, bus, io, registers, wordLength)
+		if err != nil {
+			utils.Abort("Could not listen for GDB on " + config.Remote)
+		}
+
+		debug = remote
+	}
+
+	if config.Adapter != "" {
+		adapter, err := debugger.Serve(config.Adapter, kernel, bus, io, registers, wordLength)
+		if err != nil {
+			utils.Abort("Could not serve the editor on " + config.Adapter)
+		}
+
+		debug = adapter
+	}
+
+	if debug != nil {
+		hook = debug
+		defer debug.Close()
+	}
+
+	cores := make([]cpu.Instance, 1)
+	if config.Cores > 1 {
+		cores = make([]cpu.Instance, config.Cores)
+	}
+
+	names := make([]string, len(cores))
+	for id := range names {
+		names[id] = cpu.Name(id)
+	}
+
+	memory := memory.New(config.MemoryLength, wordLength, costs.Memory, names)
+	interrupts := interrupt.New(INTERRUPTBASE, wordLength, costs.Device, encoder)
+	timer := timer.New(TIMERBASE, TIMERLINE, wordLength, costs.Device, encoder, interrupts)
+	disk := disk.New(DISKBASE, DISKLINE, (words/2)-1, DISKIMAGE, wordLength, costs.Device, encoder, interrupts)
+	defer func() {
+		if err := disk.Close(); err != nil {
+			utils.Abort("Could not close the disk image")
+		}
+	}()
+
+	caches := make([]cache.Levels, len(cores))
+	branches := make([]predictor.Instance, len(cores))
+
+	for id := range cores {
+		entry := 0
+		if id < len(config.Entries) {
+			entry = config.Entries[id]
+		}
+
+		caches[id] = cache.Build(config.Caches)
+		branches[id] = predictor.New(config.Predictor)
+		cores[id] = cpu.New(cpu.Config{
+			ID:        id,
+			Entry:     entry,
+			Registers: len(registers),
+			Word:      wordLength,
+			Memory:    words,
+			Costs:     costs,
+			Caches:    caches[id],
+			Pipeline:  config.Pipeline,
+			Predictor: branches[id],
+			Timeline:  spans,
+			Hook:      hook,
+		}, interrupts, encoder)
+
+		if debug != nil {
+			debug.Attach(cores[id], caches[id])
+		}
+
+		bus.MakeChannel(cores[id].Name())
+	}
 
-		io := io.New(encoder)
-		bus := bus.New(frequency, busLength)
-		memory := memory.New(memoryLength, wordLength, frequency)
-		cpu := cpu.New(len(registers), wordLength, (memoryLength/(wordLength/8))/4, frequency, memory, encoder)
+	bus.Attach(memory)
+	bus.Attach(interrupts)
+	bus.Attach(timer)
+	bus.Attach(disk)
 
-		bus.MakeChannel("cpu")
-		bus.MakeChannel("memory")
+	parts := components{
+		kernel:     kernel,
+		bus:        bus,
+		io:         io,
+		memory:     memory,
+		interrupts: interrupts,
+		timer:      timer,
+		disk:       disk,
+		cores:      cores,
+		caches:     caches,
+		branches:   branches,
+	}
 
-		bus.Run()
-		memory.Run(bus)
-		cpu.Run(bus)
-		io.Run(bus)
+	// The program launched by the editor was loaded by the adapter, it is
+	// not replaced
+	if config.Restore != "" {
+		io.LoadSnapshot(saved.IO)
+	} else if config.Program != "" && io.Path() == "" {
+		if err := io.Load(config.Program); err != nil {
+			utils.Abort(err.Error())
+		}
+	}
+
+	bus.Run()
+	for _, core := range cores {
+		core.Run(bus)
+	}
+	io.Run(bus)
+
+	// The machine stops once every core halted, or once every core
+	// is idle with nothing left in flight
+	kernel.Every(1, func() {
+		if all(cores, cpu.Instance.Halted) {
+			kernel.Stop()
+		}
 	})
+
+	kernel.Every(bus.Period(), func() {
+		if io.Done() && bus.Idle() && all(cores, cpu.Instance.Idle) {
+			kernel.Stop()
+		}
+	})
+
+	if config.Restore != "" {
+		if err := parts.restore(saved); err != nil {
+			utils.Abort(err.Error())
+		}
+
+		fmt.Println("Log: Restored at cycle", kernel.Cycle())
+	}
+
+	// The snapshot is taken by the last event of its cycle, once every
+	// component ran
+	if config.Snapshot != "" && config.SnapshotAt >= kernel.Cycle() {
+		kernel.Schedule(config.SnapshotAt-kernel.Cycle(), func() {
+			kernel.Schedule(0, func() {
+				if err := parts.save(config.Snapshot, config.Machine); err != nil {
+					utils.Abort(err.Error())
+				}
+
+				fmt.Println("Log: Snapshot saved at cycle", kernel.Cycle())
+			})
+		})
+	}
+
+	if config.Limit > kernel.Cycle() {
+		kernel.Schedule(config.Limit-kernel.Cycle(), kernel.Stop)
+	}
+
+	kernel.Run()
+
+	fmt.Println("")
+	fmt.Println("Log: Cycles:", kernel.Cycle())
+	fmt.Printf("Log: Elapsed: %.3f us\n", timing.Report{Cycles: kernel.Cycle(), Frequency: config.Frequency}.Elapsed())
+
+	reportBus(bus)
+
+	for id, core := range cores {
+		report(core, timing.Report{
+			Cycles:       kernel.Cycle(),
+			Instructions: core.Retired(),
+			Frequency:    config.Frequency,
+		}, caches[id], branches[id])
+	}
+
+	return parts
+}
+
+// all tells if every core satisfies the given condition
+func all(cores []cpu.Instance, condition func(cpu.Instance) bool) bool {
+	for _, core := range cores {
+		if !condition(core) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// report prints the statistics of a core
+func report(core cpu.Instance, summary timing.Report, caches cache.Levels, branches predictor.Instance) {
+	fmt.Printf("Log: %s: %d instructions, %.2f CPI\n", core.Name(), summary.Instructions, summary.CPI())
+
+	reportCaches(caches)
+
+	if branches != nil {
+		stats := branches.Stats()
+		fmt.Printf("Log: Branches: %d branches, %d mispredictions, %.2f%% accuracy\n", stats.Branches, stats.Mispredictions, stats.Accuracy()*100)
+	}
+
+	if stats, ok := core.Pipeline(); ok {
+		fmt.Printf("Log: Pipeline: %d stalls, %d flushes, %d squashed\n", stats.Stalls, stats.Flushes, stats.Squashed)
+	}
+}
+
+// reportBus prints the traffic of the bus and its usage by every master
+// and lane
+func reportBus(bus b.Instance) {
+	stats := bus.Stats()
+
+	fmt.Printf("Log: Bus: %d transfers, %d reads, %d writes, %d replies, %d coherence transactions, %d invalidations, %d flushes, %d locks\n",
+		stats.Transfers, stats.Reads, stats.Writes, stats.Replies, stats.Coherence, stats.Invalidations, stats.Flushes, stats.Locks)
+
+	for _, master := range bus.Masters() {
+		reportUsage("master "+master, stats.Masters[master], stats.Transfers)
+	}
+
+	for _, lane := range []string{b.ADDRESS, b.DATA, b.INSTUCTION} {
+		reportUsage("lane "+lane, stats.Lanes[lane], stats.Transfers)
+	}
+}
+
+// reportUsage prints the usage of the bus by a master or a lane
+func reportUsage(name string, usage b.Usage, transfers int) {
+	fmt.Printf("Log: Bus %s: %d bytes, %.2f%% utilization, %.2f cycles of wait, %.2f queue depth (max %d)\n",
+		name, usage.Bytes, usage.Utilization(transfers)*100, usage.AverageWait(), usage.AverageDepth(transfers), usage.MaxDepth)
+}
+
+// reportCaches prints the statistics of every enabled level of the caches
+func reportCaches(caches cache.Levels) {
+	names := []string{"L1I", "L1D", "L2"}
+
+	for index, level := range []cache.Instance{caches.Instruction, caches.Data, caches.Unified} {
+		if level != nil {
+			reportCache(names[index], level.Stats())
+		}
+	}
+}
+
+// reportCache prints the statistics of a cache
+func reportCache(name string, stats cache.Stats) {
+	fmt.Printf("Log: %s: %d reads, %d writes, %d hits, %d misses, %d evictions, %d writebacks, %.2f%% hit rate\n",
+		name, stats.Reads, stats.Writes, stats.Hits, stats.Misses, stats.Evictions, stats.Writebacks, stats.HitRate()*100)
 }
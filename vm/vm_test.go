@@ -0,0 +1,161 @@
+package vm
+
+import (
+	"testing"
+
+	b "github.com/bruunoromero/cpu-emulator/bus"
+	"github.com/bruunoromero/cpu-emulator/cache"
+	"github.com/bruunoromero/cpu-emulator/cpu"
+	"github.com/bruunoromero/cpu-emulator/parser"
+	"github.com/bruunoromero/cpu-emulator/predictor"
+	"github.com/bruunoromero/cpu-emulator/timing"
+)
+
+// limit is the cycle the machines of the tests are stopped at when they
+// are still running, which fails them
+const limit = 100000
+
+// machine returns the configuration of the machine built by main, running
+// a program of the repository
+func machine(program string) Config {
+	return Config{
+		Machine: Machine{
+			Registers:    []string{"A", "B", "C", "D", "E"},
+			BusLength:    16,
+			WordLength:   16,
+			MemoryLength: 1024,
+			Frequency:    100,
+			Costs:        timing.Default(),
+			Caches:       cache.Default(),
+			Predictor:    predictor.Default(),
+			Cores:        1,
+			Arbitration:  b.FIFO,
+		},
+		Program: program,
+		Limit:   limit,
+	}
+}
+
+// stopped runs a machine and fails the test when it is still running at
+// the limit
+func stopped(t *testing.T, config Config) components {
+	t.Helper()
+
+	parts := run(config)
+	if parts.kernel.Cycle() >= limit {
+		t.Fatalf("the machine was still running at cycle %d", parts.kernel.Cycle())
+	}
+
+	return parts
+}
+
+// word returns the value of a data address as the program sees it, the
+// caches holding its line are read before the memory
+func word(parts components, config Config, address int) int {
+	words := (config.MemoryLength / (config.WordLength / 8)) / 4
+	position := address + (words/2 - 1)
+
+	for _, levels := range parts.caches {
+		for _, level := range []cache.Instance{levels.Data, levels.Unified} {
+			if level == nil {
+				continue
+			}
+
+			if value, ok := level.Peek(position); ok {
+				return value
+			}
+		}
+	}
+
+	decoder := parser.NewDecoder(config.WordLength)
+	return decoder.Value(parser.Sorted(parts.bus.Read(position)))
+}
+
+// expect fails the test when the data addresses do not hold the values
+func expect(t *testing.T, parts components, config Config, values map[int]int) {
+	t.Helper()
+
+	for address, value := range values {
+		if got := word(parts, config, address); got != value {
+			t.Errorf("address %#x holds %d, want %d", address, got, value)
+		}
+	}
+}
+
+func TestSingleCore(t *testing.T) {
+	config := machine("../code.s")
+	parts := stopped(t, config)
+
+	expect(t, parts, config, map[int]int{0x001: 12, 0x002: 5, 0x003: 60, 0x004: 2})
+}
+
+func TestPipeline(t *testing.T) {
+	config := machine("../code.s")
+	config.Pipeline = cpu.Pipeline{Enabled: true, Forwarding: true}
+	config.Predictor.Kind = predictor.TWOBIT
+	parts := stopped(t, config)
+
+	expect(t, parts, config, map[int]int{0x001: 12, 0x002: 5, 0x003: 60, 0x004: 2})
+}
+
+func TestMultiCore(t *testing.T) {
+	for _, protocol := range []int{cache.NONE, cache.MSI, cache.MESI} {
+		config := machine("../code.s")
+		config.Cores = 2
+		config.Caches.Coherence = protocol
+		parts := stopped(t, config)
+
+		expect(t, parts, config, map[int]int{0x002: 5, 0x004: 2})
+	}
+}
+
+// TestAtomicRefill runs two cores adding to a shared counter, one of them
+// locking the bus while a line refill of the other is partly transferred
+func TestAtomicRefill(t *testing.T) {
+	for _, protocol := range []int{cache.NONE, cache.MSI, cache.MESI} {
+		config := machine("testdata/xadd.s")
+		config.BusLength = 8
+		config.Frequency = 1
+		config.Cores = 2
+		config.Caches.Coherence = protocol
+		parts := stopped(t, config)
+
+		if !all(parts.cores, cpu.Instance.Halted) {
+			t.Errorf("the cores did not halt with coherence %d", protocol)
+		}
+
+		expect(t, parts, config, map[int]int{0x010: 10})
+	}
+}
+
+// TestCacheLevels runs the program with levels of the hierarchy disabled
+// or slowed down
+func TestCacheLevels(t *testing.T) {
+	for name, change := range map[string]func(*cache.Hierarchy){
+		"no first level": func(caches *cache.Hierarchy) {
+			caches.Instruction.Size = 0
+			caches.Data.Size = 0
+		},
+		"no second level": func(caches *cache.Hierarchy) {
+			caches.Unified.Size = 0
+		},
+		"no caches": func(caches *cache.Hierarchy) {
+			*caches = cache.Hierarchy{Coherence: caches.Coherence}
+		},
+		"slow levels": func(caches *cache.Hierarchy) {
+			caches.Data.Latency = 3
+			caches.Unified.Latency = 10
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, cores := range []int{1, 2} {
+				config := machine("../code.s")
+				config.Cores = cores
+				change(&config.Caches)
+				parts := stopped(t, config)
+
+				expect(t, parts, config, map[int]int{0x002: 5, 0x004: 2})
+			}
+		})
+	}
+}